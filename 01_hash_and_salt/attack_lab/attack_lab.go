@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	mathrand "math/rand/v2"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ===================
+// オフライン攻撃の実験
+// ===================
+
+// DBが漏洩したと仮定して、同じユーザー・同じパスワードを3通りの方法で保存し、
+// 手元の単語リストでどれだけ解読できるかを比べる。
+//
+//	A: sha256(パスワード)          → 事前計算したテーブルを引くだけ
+//	B: sha256(パスワード + ソルト)  → ユーザーごとに辞書を計算し直す
+//	C: bcrypt                      → 1回の計算が遅い
+//
+// 攻撃者が手元で計算できるハッシュは全て使ってよい前提（オンラインのレート制限は関係ない）。
+
+// 漏洩したユーザー1人分（Password は答え合わせ用で、攻撃側は見ない）
+type Victim struct {
+	Username string
+	Password string
+	Salt     string
+	SHA256   string // A: ソルトなし
+	Salted   string // B: ソルトあり
+	Bcrypt   []byte // C: bcrypt
+}
+
+// 1つの攻撃の結果
+type Report struct {
+	Name     string
+	Cracked  int
+	Total    int
+	Hashes   int // 攻撃中に計算したハッシュの数
+	Duration time.Duration
+	Note     string
+}
+
+func (r Report) HashesPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Hashes) / r.Duration.Seconds()
+}
+
+// --- 辞書 ---
+
+// 単語リストを読み込む（空行と # で始まる行は無視）
+func loadWordlist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		w := strings.TrimSpace(scanner.Text())
+		if w == "" || strings.HasPrefix(w, "#") {
+			continue
+		}
+		words = append(words, w)
+	}
+	return words, scanner.Err()
+}
+
+// よくある変形（先頭大文字、末尾に数字や記号）で辞書を膨らませる
+func expand(words []string) []string {
+	suffixes := []string{"", "1", "12", "123", "!", "2024", "2025"}
+
+	seen := make(map[string]bool)
+	var candidates []string
+	for _, w := range words {
+		for _, base := range []string{w, strings.ToUpper(w[:1]) + w[1:]} {
+			for _, suffix := range suffixes {
+				c := base + suffix
+				if !seen[c] {
+					seen[c] = true
+					candidates = append(candidates, c)
+				}
+			}
+		}
+	}
+	return candidates
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return hex.EncodeToString(b)[:n]
+}
+
+// --- 漏洩したDBを作る ---
+
+// weakRatio の割合のユーザーは辞書にあるパスワードを使い、残りはランダムな強いパスワードにする
+func makeVictims(n int, weakRatio float64, candidates []string, cost int) ([]Victim, error) {
+	victims := make([]Victim, n)
+	for i := range victims {
+		password := randomString(16)
+		if mathrand.Float64() < weakRatio {
+			password = candidates[mathrand.IntN(len(candidates))]
+		}
+		salt := randomString(16)
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+		if err != nil {
+			return nil, err
+		}
+		victims[i] = Victim{
+			Username: fmt.Sprintf("user%03d", i+1),
+			Password: password,
+			Salt:     salt,
+			SHA256:   sha256Hex(password),
+			Salted:   sha256Hex(password + salt),
+			Bcrypt:   hash,
+		}
+	}
+	return victims, nil
+}
+
+// --- 攻撃 ---
+
+// A: ソルトなし。辞書のハッシュを1回だけ計算してテーブルにしておけば、全員に使い回せる
+func attackUnsalted(victims []Victim, candidates []string) (Report, map[string]string) {
+	start := time.Now()
+
+	// 事前計算テーブル（ハッシュ → パスワード）。本物のレインボーテーブルはチェーンで圧縮するが、考え方は同じ
+	table := make(map[string]string, len(candidates))
+	for _, c := range candidates {
+		table[sha256Hex(c)] = c
+	}
+
+	cracked := 0
+	for _, v := range victims {
+		if p, ok := table[v.SHA256]; ok && p == v.Password {
+			cracked++
+		}
+	}
+
+	return Report{
+		Name:     "A: sha256（ソルトなし）",
+		Cracked:  cracked,
+		Total:    len(victims),
+		Hashes:   len(candidates),
+		Duration: time.Since(start),
+		Note:     "テーブルは1回作れば全ユーザー・他のサービスの漏洩にも使い回せる",
+	}, table
+}
+
+// B: ソルトあり。A のテーブルは役に立たないので、ユーザーごとに辞書を計算し直す
+func attackSalted(victims []Victim, candidates []string, table map[string]string) Report {
+	start := time.Now()
+
+	// まずテーブルを引いてみる（ソルトが入っているので当たらない）
+	tableHits := 0
+	for _, v := range victims {
+		if _, ok := table[v.Salted]; ok {
+			tableHits++
+		}
+	}
+
+	cracked, hashes := 0, 0
+	for _, v := range victims {
+		for _, c := range candidates {
+			hashes++
+			if sha256Hex(c+v.Salt) == v.Salted {
+				cracked++
+				break
+			}
+		}
+	}
+
+	return Report{
+		Name:     "B: sha256 + ソルト",
+		Cracked:  cracked,
+		Total:    len(victims),
+		Hashes:   hashes,
+		Duration: time.Since(start),
+		Note:     fmt.Sprintf("事前計算テーブルの命中 %d件。計算量はユーザー数倍になるが、sha256は速いので辞書攻撃は成立する", tableHits),
+	}
+}
+
+// C: bcrypt。ユーザーごとに計算し直すうえ、1回が遅い。制限時間内にどこまで進むかを見る
+func attackBcrypt(victims []Victim, candidates []string, limit time.Duration) Report {
+	start := time.Now()
+	deadline := start.Add(limit)
+
+	cracked, hashes := 0, 0
+	finished := true
+attack:
+	for _, v := range victims {
+		for _, c := range candidates {
+			if time.Now().After(deadline) {
+				finished = false
+				break attack
+			}
+			hashes++
+			if bcrypt.CompareHashAndPassword(v.Bcrypt, []byte(c)) == nil {
+				cracked++
+				break
+			}
+		}
+	}
+
+	report := Report{
+		Name:     "C: bcrypt",
+		Cracked:  cracked,
+		Total:    len(victims),
+		Hashes:   hashes,
+		Duration: time.Since(start),
+	}
+	if !finished {
+		// 辞書を全員分試すのにかかる時間を推定する
+		full := time.Duration(float64(len(victims)*len(candidates)) / report.HashesPerSecond() * float64(time.Second))
+		report.Note = fmt.Sprintf("制限時間 %v で打ち切り。全員に辞書全体を試すと約 %v かかる", limit, full.Round(time.Second))
+	}
+	return report
+}
+
+func printReport(r Report) {
+	fmt.Printf("--- %s ---\n", r.Name)
+	fmt.Printf("  解読:       %d / %d 人 (%.0f%%)\n", r.Cracked, r.Total, float64(r.Cracked)/float64(r.Total)*100)
+	fmt.Printf("  計算回数:   %d\n", r.Hashes)
+	fmt.Printf("  所要時間:   %v\n", r.Duration.Round(time.Microsecond))
+	fmt.Printf("  速度:       %.0f hashes/秒\n", r.HashesPerSecond())
+	if r.Note != "" {
+		fmt.Printf("  → %s\n", r.Note)
+	}
+	fmt.Println()
+}
+
+func main() {
+	wordlistPath := flag.String("wordlist", "attack_lab/wordlist.txt", "攻撃に使う単語リスト")
+	users := flag.Int("users", 20, "漏洩したユーザー数")
+	weakRatio := flag.Float64("weak", 0.7, "辞書にあるパスワードを使っているユーザーの割合")
+	cost := flag.Int("cost", bcrypt.DefaultCost, "被害者側の bcrypt コスト")
+	limit := flag.Duration("bcrypt-limit", 10*time.Second, "bcrypt 攻撃の制限時間")
+	flag.Parse()
+
+	words, err := loadWordlist(*wordlistPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	candidates := expand(words)
+
+	fmt.Println("=== オフライン攻撃ラボ ===")
+	fmt.Printf("単語リスト: %d 語 → 変形込みで %d 通り\n", len(words), len(candidates))
+	fmt.Printf("漏洩ユーザー: %d 人（うち約 %.0f%% が辞書にあるパスワード）\n", *users, *weakRatio*100)
+	fmt.Println()
+
+	fmt.Println("漏洩したDBを準備中...")
+	victims, err := makeVictims(*users, *weakRatio, candidates, *cost)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println()
+
+	reportA, table := attackUnsalted(victims, candidates)
+	printReport(reportA)
+	printReport(attackSalted(victims, candidates, table))
+	printReport(attackBcrypt(victims, candidates, *limit))
+
+	fmt.Println("【まとめ】")
+	fmt.Println("  - ソルトなし: テーブルを1回作るだけで、同じパスワードの人がまとめて解読される")
+	fmt.Println("  - ソルトあり: テーブルは無効になるが、sha256は速いので1人ずつ辞書攻撃できる")
+	fmt.Println("  - bcrypt:     1回の計算が遅いので、同じ辞書でも桁違いに時間がかかる")
+	fmt.Println("  → どの方式でも辞書にあるパスワードはいずれ破られる。強いパスワードを使うことが前提")
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 空行と # の行は読み飛ばす
+func TestLoadWordlist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte("# コメント\npassword\n\n  dragon  \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	words, err := loadWordlist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(words, []string{"password", "dragon"}) {
+		t.Errorf("words = %q", words)
+	}
+}
+
+// 先頭大文字と末尾の変形を足し、重複は1つにまとめる
+func TestExpand(t *testing.T) {
+	candidates := expand([]string{"abc", "Abc"})
+	for _, want := range []string{"abc", "Abc", "abc123", "Abc!", "abc2025"} {
+		if !slices.Contains(candidates, want) {
+			t.Errorf("%q がない", want)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if seen[c] {
+			t.Errorf("%q が重複している", c)
+		}
+		seen[c] = true
+	}
+}
+
+// 辞書にあるパスワードはどの方式でも解読でき、ランダムなパスワードはどれでも解読できない。
+// ソルトありのハッシュには、ソルトなしで作ったテーブルが当たらない
+func TestAttacksCrackOnlyDictionaryPasswords(t *testing.T) {
+	candidates := expand([]string{"password", "dragon", "sunshine"})
+
+	for _, tc := range []struct {
+		name      string
+		weakRatio float64
+		want      int
+	}{
+		{"全員が辞書のパスワード", 1, 5},
+		{"全員がランダムなパスワード", 0, 0},
+	} {
+		victims, err := makeVictims(5, tc.weakRatio, candidates, bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reportA, table := attackUnsalted(victims, candidates)
+		reportB := attackSalted(victims, candidates, table)
+		reportC := attackBcrypt(victims, candidates, time.Minute)
+		for _, r := range []Report{reportA, reportB, reportC} {
+			if r.Cracked != tc.want || r.Total != len(victims) {
+				t.Errorf("%s: %s の解読 %d / %d, want %d", tc.name, r.Name, r.Cracked, r.Total, tc.want)
+			}
+		}
+		if !strings.Contains(reportB.Note, "命中 0件") {
+			t.Errorf("%s: ソルトありにテーブルが当たった: %s", tc.name, reportB.Note)
+		}
+		if reportA.Hashes != len(candidates) {
+			t.Errorf("%s: テーブルの計算回数 = %d, want %d", tc.name, reportA.Hashes, len(candidates))
+		}
+	}
+}
+
+// bcrypt の攻撃は制限時間で打ち切り、全体にかかる時間の見積もりを出す
+func TestAttackBcryptStopsAtLimit(t *testing.T) {
+	candidates := expand([]string{"password"})
+	victims, err := makeVictims(2, 0, candidates, bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := attackBcrypt(victims, candidates, 10*time.Millisecond)
+	if r.Hashes >= len(victims)*len(candidates) {
+		t.Skip("制限時間内に全て試せた（マシンが速すぎる）")
+	}
+	if !strings.Contains(r.Note, "打ち切り") {
+		t.Errorf("打ち切りの説明がない: %q", r.Note)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ===================
+// コストの計測（キャリブレーション）
+// ===================
+
+// bcrypt.DefaultCost（10）が速すぎるか遅すぎるかはマシン次第。
+// このマシンで実際に計測して、1回の検証が目標時間に収まる最大のパラメータを選ぶ。
+
+// bcrypt のコストの下限。速いマシンでもこれより下げると、オフラインの総当たりに弱くなりすぎる
+const minBcryptCost = bcrypt.DefaultCost
+
+// 計測に使うパスワード（値自体は何でもよい）
+const samplePassword = "correct horse battery staple"
+
+// 書き込み先（01_hash_and_salt ディレクトリから実行した場合の相対パス）
+var defaultConfigs = []string{
+	"../02_inmemory_auth/config.json",
+	"../03_session_auth/02_session_server/config.json",
+	"../04_jwt_auth/02_jwt_server/config.json",
+}
+
+// 1つの候補パラメータの計測結果
+type Result struct {
+	Label    string
+	Duration time.Duration
+}
+
+// f を数回実行して中央値を返す（1回目はGCなどのぶれが出やすいので回数で均す）
+func measure(rounds int, f func()) time.Duration {
+	durations := make([]time.Duration, rounds)
+	for i := range durations {
+		start := time.Now()
+		f()
+		durations[i] = time.Since(start)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations[rounds/2]
+}
+
+// --- bcrypt ---
+
+// コストを1ずつ上げ、目標時間を超えたら止める。コストが1増えると計算量は2倍。
+// 下限（minBcryptCost）でも目標時間を超えるときは、下限をそのまま勧める
+func calibrateBcrypt(budget time.Duration, rounds int) (int, []Result) {
+	best := minBcryptCost
+	var results []Result
+
+	for cost := minBcryptCost; cost <= bcrypt.MaxCost; cost++ {
+		hash, err := bcrypt.GenerateFromPassword([]byte(samplePassword), cost)
+		if err != nil {
+			log.Fatal(err)
+		}
+		d := measure(rounds, func() {
+			bcrypt.CompareHashAndPassword(hash, []byte(samplePassword))
+		})
+		results = append(results, Result{fmt.Sprintf("cost=%d", cost), d})
+
+		if d > budget {
+			break
+		}
+		best = cost
+	}
+	return best, results
+}
+
+// --- scrypt ---
+
+type ScryptParams struct {
+	N, R, P int
+}
+
+// N を2倍ずつ上げる（メモリ使用量は 128 * N * r バイト）。
+// 最小構成（N=2^14, r=8 で16MiB）も最大メモリに収まらなければエラー
+func calibrateScrypt(budget time.Duration, rounds int, maxMemoryKiB uint32) (ScryptParams, []Result, error) {
+	best := ScryptParams{N: 1 << 14, R: 8, P: 1}
+	if memory := 128 * best.N * best.R / 1024; uint64(memory) > uint64(maxMemoryKiB) {
+		return best, nil, fmt.Errorf("最大メモリ %dMiB では scrypt の最小構成（%dMiB）を試せません", maxMemoryKiB/1024, memory/1024)
+	}
+	var results []Result
+	salt := []byte("calibration-salt")
+
+	for logN := 14; logN <= 22; logN++ {
+		params := ScryptParams{N: 1 << logN, R: 8, P: 1}
+		if uint64(128*params.N*params.R) > uint64(maxMemoryKiB)*1024 {
+			break
+		}
+		d := measure(rounds, func() {
+			if _, err := scrypt.Key([]byte(samplePassword), salt, params.N, params.R, params.P, 32); err != nil {
+				log.Fatal(err)
+			}
+		})
+		results = append(results, Result{fmt.Sprintf("N=2^%d,r=%d,p=%d", logN, params.R, params.P), d})
+
+		if d > budget {
+			break
+		}
+		best = params
+	}
+	return best, results, nil
+}
+
+// --- Argon2id ---
+
+type Argon2Params struct {
+	Memory  uint32 `json:"memory"`
+	Time    uint32 `json:"time"`
+	Threads uint8  `json:"threads"`
+}
+
+// RFC 9106 の方針どおり、まずメモリを増やし、その中で反復回数を増やす。
+// 各メモリ量で目標時間に収まる最大の t を求め、m*t が最大のものを選ぶ。
+// 最小構成も最大メモリに収まらなければエラー（上限を超える値を勧めない）
+func calibrateArgon2id(budget time.Duration, rounds int, maxMemoryKiB uint32, threads uint8) (Argon2Params, []Result, error) {
+	best := Argon2Params{Memory: 19 * 1024, Time: 2, Threads: threads} // OWASPの最小構成
+	if best.Memory > maxMemoryKiB {
+		return best, nil, fmt.Errorf("最大メモリ %dMiB では Argon2id の最小構成（%dMiB）を試せません", maxMemoryKiB/1024, best.Memory/1024)
+	}
+	bestWork := uint64(0)
+	var results []Result
+	salt := []byte("calibration-salt")
+
+	for _, memory := range []uint32{19 * 1024, 32 * 1024, 64 * 1024, 128 * 1024, 256 * 1024, 512 * 1024, 1024 * 1024} {
+		if memory > maxMemoryKiB {
+			break
+		}
+		fitted := false
+		for t := uint32(1); t <= 10; t++ {
+			d := measure(rounds, func() {
+				argon2.IDKey([]byte(samplePassword), salt, t, memory, threads, 32)
+			})
+			results = append(results, Result{fmt.Sprintf("m=%dMiB,t=%d,p=%d", memory/1024, t, threads), d})
+
+			if d > budget {
+				break
+			}
+			fitted = true
+			if work := uint64(memory) * uint64(t); work > bestWork {
+				best = Argon2Params{Memory: memory, Time: t, Threads: threads}
+				bestWork = work
+			}
+		}
+		// t=1 でも収まらなければ、これ以上メモリを増やしても無駄
+		if !fitted {
+			break
+		}
+	}
+	return best, results, nil
+}
+
+// --- 設定ファイルへの書き込み ---
+
+// 既存の設定（algorithm や pepper_keyring など）は残したまま、コストだけを書き換える
+func writeConfig(path string, bcryptCost int, argon2Params Argon2Params) error {
+	cfg := make(map[string]any)
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("%s のパースに失敗: %w", path, err)
+		}
+	}
+
+	hash, _ := cfg["hash"].(map[string]any)
+	if hash == nil {
+		hash = make(map[string]any)
+	}
+	argon2Cfg, _ := hash["argon2"].(map[string]any)
+	if argon2Cfg == nil {
+		argon2Cfg = make(map[string]any)
+	}
+	argon2Cfg["memory"] = argon2Params.Memory
+	argon2Cfg["time"] = argon2Params.Time
+	argon2Cfg["threads"] = argon2Params.Threads
+
+	hash["bcrypt_cost"] = max(bcryptCost, minBcryptCost)
+	hash["argon2"] = argon2Cfg
+	cfg["hash"] = hash
+
+	out, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(out, '\n'), 0644)
+}
+
+func printResults(results []Result, budget time.Duration) {
+	for _, r := range results {
+		mark := "  "
+		if r.Duration > budget {
+			mark = "✗ "
+		}
+		fmt.Printf("  %s%-22s %v\n", mark, r.Label, r.Duration.Round(time.Millisecond))
+	}
+	if len(results) > 0 && results[0].Duration > budget {
+		fmt.Println("  ※ 最小のパラメータでも目標時間を超えています（推奨値は最小のパラメータ）")
+	}
+}
+
+func main() {
+	budget := flag.Duration("budget", 250*time.Millisecond, "1回の検証にかけてよい時間")
+	rounds := flag.Int("rounds", 3, "1つのパラメータを計測する回数")
+	maxMemory := flag.Uint("max-memory", 256, "Argon2id / scrypt で使ってよい最大メモリ（MiB）")
+	threads := flag.Uint("threads", 1, "Argon2id の並列度")
+	write := flag.Bool("write", false, "推奨値をサーバーの config.json に書き込む")
+	configs := flag.String("configs", strings.Join(defaultConfigs, ","), "書き込み先の config.json（カンマ区切り）")
+	flag.Parse()
+
+	// 0 だと argon2 が panic し、メモリ 0 では何も計測できない
+	if *threads < 1 || *threads > 255 {
+		log.Fatalf("-threads は1〜255で指定してください: %d", *threads)
+	}
+	if *maxMemory < 1 || *maxMemory >= 4*1024*1024 { // KiB にすると uint32 に収まらない
+		log.Fatalf("-max-memory は1〜%d（MiB）で指定してください: %d", 4*1024*1024-1, *maxMemory)
+	}
+
+	maxMemoryKiB := uint32(*maxMemory) * 1024
+
+	fmt.Println("=== パスワードハッシュのキャリブレーション ===")
+	fmt.Println("目標時間:", *budget)
+	fmt.Println()
+
+	fmt.Println("--- bcrypt ---")
+	bcryptCost, results := calibrateBcrypt(*budget, *rounds)
+	printResults(results, *budget)
+	fmt.Printf("→ 推奨: cost=%d（下限は %d）\n", bcryptCost, minBcryptCost)
+	fmt.Println()
+
+	fmt.Println("--- scrypt ---")
+	if scryptParams, results, err := calibrateScrypt(*budget, *rounds, maxMemoryKiB); err != nil {
+		fmt.Println("  ※", err) // サーバーは scrypt を使わないので、計測できなくても続ける
+	} else {
+		printResults(results, *budget)
+		fmt.Printf("→ 推奨: N=%d, r=%d, p=%d（サーバーは未対応なので参考値）\n", scryptParams.N, scryptParams.R, scryptParams.P)
+	}
+	fmt.Println()
+
+	fmt.Println("--- Argon2id ---")
+	argon2Params, results, err := calibrateArgon2id(*budget, *rounds, maxMemoryKiB, uint8(*threads))
+	if err != nil {
+		log.Fatal(err)
+	}
+	printResults(results, *budget)
+	fmt.Printf("→ 推奨: m=%dMiB, t=%d, p=%d\n", argon2Params.Memory/1024, argon2Params.Time, argon2Params.Threads)
+	fmt.Println()
+
+	if !*write {
+		fmt.Println("-write を付けると推奨値をサーバーの config.json に書き込みます")
+		return
+	}
+	for _, path := range strings.Split(*configs, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if err := writeConfig(path, bcryptCost, argon2Params); err != nil {
+			log.Fatalf("%s への書き込みに失敗: %v", path, err)
+		}
+		fmt.Println("書き込みました:", path)
+	}
+}
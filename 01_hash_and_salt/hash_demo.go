@@ -2,7 +2,10 @@ package main
 
 import (
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
 	"fmt"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -42,6 +45,46 @@ func main() {
 	fmt.Println("間違ったパスワードで検証:", err == nil) // false
 	err = bcrypt.CompareHashAndPassword(hash2, []byte("wrongpassword"))
 	fmt.Println("間違ったパスワードで検証 hash2:", err == nil) // false
+	fmt.Println()
+
+	longPassword()
+}
+
+// bcryptの72バイト制限
+func longPassword() {
+	// 72バイトまで同じで、73バイト目以降だけが違う2つのパスフレーズ
+	common := strings.Repeat("correct horse battery staple ", 3)[:72]
+	passA := common + "-alice"
+	passB := common + "-bob"
+
+	fmt.Println("=== bcryptの72バイト制限 ===")
+	fmt.Println("パスフレーズA:", len(passA), "バイト")
+	fmt.Println("パスフレーズB:", len(passB), "バイト（先頭72バイトはAと同じ）")
+	fmt.Println()
+
+	// 今のライブラリは72バイトを超えるとエラーにする
+	_, err := bcrypt.GenerateFromPassword([]byte(passA), bcrypt.DefaultCost)
+	fmt.Println("そのままハッシュ化:", err)
+
+	// 検証側は黙って72バイトで切り捨てる → 先頭72バイトが同じなら通ってしまう
+	truncated, _ := bcrypt.GenerateFromPassword([]byte(passA[:72]), bcrypt.DefaultCost)
+	err = bcrypt.CompareHashAndPassword(truncated, []byte(passB))
+	fmt.Println("72バイトで切ったハッシュをBで検証:", err == nil) // true（危険！）
+	fmt.Println()
+
+	// 事前ハッシュ: SHA-384 → base64（64文字）にしてから bcrypt
+	preHash := func(p string) []byte {
+		sum := sha512.Sum384([]byte(p))
+		return []byte(base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	hashA, _ := bcrypt.GenerateFromPassword(preHash(passA), bcrypt.DefaultCost)
+
+	fmt.Println("=== 事前ハッシュ（SHA-384 + base64）===")
+	fmt.Println("事前ハッシュ後の長さ:", len(preHash(passA)), "バイト")
+	err = bcrypt.CompareHashAndPassword(hashA, preHash(passA))
+	fmt.Println("Aのハッシュを A で検証:", err == nil) // true
+	err = bcrypt.CompareHashAndPassword(hashA, preHash(passB))
+	fmt.Println("Aのハッシュを B で検証:", err == nil) // false（73バイト目以降も効いている）
 }
 
 
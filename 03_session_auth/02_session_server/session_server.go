@@ -1,284 +1,101 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/base64"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
-	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/shin6142/go-login/auth"
 )
 
-// ===================
-// ユーザー管理
-// ===================
+// ユーザー管理・セッション管理・HTTPハンドラーは auth パッケージ（../../auth）にある。
+// auth.API に SessionRepository を渡すと、ログイン時にセッションを作って Cookie で返す。
 
-type User struct {
-	Username     string
-	PasswordHash []byte
-}
-
-type UserStore struct {
-	users map[string]*User
-}
-
-func NewUserStore() *UserStore {
-	return &UserStore{users: make(map[string]*User)}
-}
-
-func (s *UserStore) Register(username, password string) error {
-	if _, exists := s.users[username]; exists {
-		return fmt.Errorf("ユーザー '%s' は既に存在します", username)
-	}
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return err
-	}
-	s.users[username] = &User{Username: username, PasswordHash: hash}
-	return nil
-}
-
-func (s *UserStore) Authenticate(username, password string) (*User, error) {
-	user, exists := s.users[username]
-	if !exists {
-		return nil, fmt.Errorf("ユーザーが見つかりません")
-	}
-	if err := bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)); err != nil {
-		return nil, fmt.Errorf("パスワードが間違っています")
-	}
-	return user, nil
-}
-
-// ===================
-// セッション管理
-// ===================
-
-type Session struct {
-	ID        string
-	Username  string
-	CreatedAt time.Time
-	ExpiresAt time.Time
-}
-
-type SessionStore struct {
-	sessions map[string]*Session // key: セッションID
-}
-
-func NewSessionStore() *SessionStore {
-	return &SessionStore{sessions: make(map[string]*Session)}
-}
-
-// ログ出力用
-func (s *SessionStore) String() string {
-	if len(s.sessions) == 0 {
-		return "セッションなし"
-	}
-	result := fmt.Sprintf("セッション数: %d\n", len(s.sessions))
-	for id, session := range s.sessions {
-		result += fmt.Sprintf("  - ID: %s... / User: %s\n", id[:16], session.Username)
-	}
-	return result
-}
-
-// セッションIDを生成（32バイトのランダムな文字列）
-func generateSessionID() (string, error) {
-	b := make([]byte, 32)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
-	}
-	return base64.URLEncoding.EncodeToString(b), nil
-}
-
-// 新しいセッションを作成
-func (s *SessionStore) Create(username string) (*Session, error) {
-	id, err := generateSessionID()
-	if err != nil {
-		return nil, err
-	}
-
-	session := &Session{
-		ID:        id,
-		Username:  username,
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(24 * time.Hour), // 24時間有効
-	}
-	s.sessions[id] = session
-	return session, nil
-}
-
-// セッションIDからセッションを取得
-func (s *SessionStore) Get(id string) (*Session, error) {
-	session, exists := s.sessions[id]
-	if !exists {
-		return nil, fmt.Errorf("セッションが見つかりません")
-	}
-	// 有効期限チェック
-	if time.Now().After(session.ExpiresAt) {
-		delete(s.sessions, id)
-		return nil, fmt.Errorf("セッションが期限切れです")
-	}
-	return session, nil
-}
-
-// セッションを削除
-func (s *SessionStore) Delete(id string) {
-	delete(s.sessions, id)
-}
-
-// ===================
-// HTTPハンドラー
-// ===================
-
-type Server struct {
-	users    *UserStore
-	sessions *SessionStore
-}
-
-type AuthRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-}
-
-type Response struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-}
-
-func jsonResponse(w http.ResponseWriter, status int, resp Response) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(resp)
-}
-
-// ユーザー登録
-func (s *Server) HandleRegister(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		jsonResponse(w, http.StatusMethodNotAllowed, Response{false, "POSTメソッドを使用してください"})
-		return
-	}
-
-	var req AuthRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		jsonResponse(w, http.StatusBadRequest, Response{false, "無効なJSONです"})
-		return
-	}
-
-	if err := s.users.Register(req.Username, req.Password); err != nil {
-		jsonResponse(w, http.StatusConflict, Response{false, err.Error()})
-		return
-	}
-
-	log.Printf("ユーザー登録: %s", req.Username)
-	log.Printf("セッション: %s", s.sessions)
-	jsonResponse(w, http.StatusCreated, Response{true, "登録しました"})
-}
-
-// ログイン
-func (s *Server) HandleLogin(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		jsonResponse(w, http.StatusMethodNotAllowed, Response{false, "POSTメソッドを使用してください"})
-		return
-	}
-
-	var req AuthRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		jsonResponse(w, http.StatusBadRequest, Response{false, "無効なJSONです"})
-		return
-	}
-
-	// パスワード認証
-	user, err := s.users.Authenticate(req.Username, req.Password)
-	if err != nil {
-		jsonResponse(w, http.StatusUnauthorized, Response{false, err.Error()})
-		return
-	}
+func main() {
+	commands := auth.RegisterFlags(flag.CommandLine)
+	flag.Parse()
 
-	// セッション作成
-	session, err := s.sessions.Create(user.Username)
+	cfg, err := auth.LoadConfig(auth.ConfigPath)
 	if err != nil {
-		jsonResponse(w, http.StatusInternalServerError, Response{false, "セッション作成に失敗"})
-		return
+		log.Fatal(err)
 	}
-
-	// CookieにセッションIDを設定
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    session.ID,
-		Path:     "/",
-		HttpOnly: true,
-		Expires:  session.ExpiresAt,
-	})
-
-	log.Printf("ログイン成功: %s (セッションID: %s...)", user.Username, session.ID[:16])
-	jsonResponse(w, http.StatusOK, Response{true, fmt.Sprintf("ようこそ、%s さん！", user.Username)})
-}
-
-// プロフィール（認証が必要）
-func (s *Server) HandleProfile(w http.ResponseWriter, r *http.Request) {
-	// CookieからセッションIDを取得
-	cookie, err := r.Cookie("session_id")
-	if err != nil {
-		jsonResponse(w, http.StatusUnauthorized, Response{false, "ログインしてください"})
+	if done, err := commands.Run(cfg); done {
+		if err != nil {
+			log.Fatal(err)
+		}
 		return
 	}
 
-	// セッションを検証
-	session, err := s.sessions.Get(cookie.Value)
+	// 保存先（config.json の store.backend: memory / sqlite）
+	backend, err := auth.OpenBackend(cfg.Store)
 	if err != nil {
-		jsonResponse(w, http.StatusUnauthorized, Response{false, err.Error()})
-		return
+		log.Fatal(err)
 	}
+	defer backend.Close()
 
-	// 認証成功！
-	jsonResponse(w, http.StatusOK, Response{true, fmt.Sprintf("こんにちは、%s さん！", session.Username)})
-}
-
-// ログアウト
-func (s *Server) HandleLogout(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		jsonResponse(w, http.StatusMethodNotAllowed, Response{false, "POSTメソッドを使用してください"})
-		return
-	}
-
-	// CookieからセッションIDを取得
-	cookie, err := r.Cookie("session_id")
+	users, pool, err := auth.NewUserStoreFromConfig(cfg, backend.Users)
 	if err != nil {
-		jsonResponse(w, http.StatusOK, Response{true, "既にログアウトしています"})
-		return
-	}
-
-	// セッションを削除
-	s.sessions.Delete(cookie.Value)
-
-	// Cookieを削除
-	http.SetCookie(w, &http.Cookie{
-		Name:    "session_id",
-		Value:   "",
-		Path:    "/",
-		Expires: time.Unix(0, 0),
-		MaxAge:  -1,
-	})
-
-	log.Printf("ログアウト: セッションID %s...", cookie.Value[:16])
-	jsonResponse(w, http.StatusOK, Response{true, "ログアウトしました"})
-}
-
-func main() {
-	server := &Server{
-		users:    NewUserStore(),
-		sessions: NewSessionStore(),
+		log.Fatal(err)
+	}
+	commands.ImportUsers(users, cfg)
+	// 管理者がいなければ作る（config.json の admin）
+	if err := auth.BootstrapAdmin(users, cfg.Admin); err != nil {
+		log.Fatal(err)
+	}
+	// 退会してから猶予期間（config.json の account.purge_after_days）が過ぎたアカウントを消す
+	stopPurger := auth.NewAccountPurgerFromConfig(cfg, users, backend.Sessions, backend.OneTimeTokens, backend.LoginHistory).Start()
+	defer stopPurger()
+
+	throttle := auth.NewLoginThrottle(cfg.Lockout)
+
+	server := &auth.API{
+		Users:    users,
+		Sessions: backend.Sessions,
+		// config.json の email.verify が true なら、メールアドレスを確認するまでログインさせない
+		Verifier: auth.NewEmailVerifierFromConfig(cfg, users, backend.OneTimeTokens),
+		// メールアドレスを登録したユーザーは、/password/forgot でリセットリンクを受け取れる
+		Resetter: auth.NewPasswordResetterFromConfig(cfg, users, backend.OneTimeTokens, backend.Sessions),
+		// 登録・再送・リセットのメールを裏で送る数と、同じアドレスへの間隔（config.json の email.send_workers など）
+		Mail: auth.NewMailQueueFromConfig(cfg.Email),
+		// アカウントごとのログイン試行の制限（config.json の lockout）
+		Throttle: throttle,
+		// ロールごとの権限（config.json の roles）
+		Roles: cfg.Roles,
+		// ログインの日時・接続元（/account/export で本人が見られる）
+		History: backend.LoginHistory,
 	}
 
 	http.HandleFunc("/register", server.HandleRegister)
 	http.HandleFunc("/login", server.HandleLogin)
-	http.HandleFunc("/profile", server.HandleProfile)
+	http.HandleFunc("/profile", server.Require(auth.PermProfileRead, server.HandleProfile))
+	http.HandleFunc("/password/change", server.Require(auth.PermPasswordChange, server.HandleChangePassword))
+	http.HandleFunc("GET /account/export", server.Require(auth.PermAccountExport, server.HandleAccountExport))
+	http.HandleFunc("POST /account/delete", server.Require(auth.PermAccountDelete, server.HandleAccountDelete))
+	http.HandleFunc("/email/verify", server.HandleVerifyEmail)
+	http.HandleFunc("/email/resend", server.HandleResendVerification)
+	http.HandleFunc("/password/forgot", server.HandleForgotPassword)
+	http.HandleFunc("/password/reset", server.HandleResetPassword)
+	http.HandleFunc("/stats/hashpool", server.Require(auth.PermStatsRead, pool.HandleStats))
+	http.HandleFunc("/admin/lockouts", server.Require(auth.PermLockoutsRead, throttle.HandleLockouts))
+	// ユーザー管理（admin ロール）
+	http.HandleFunc("GET /admin/users", server.Require(auth.PermUsersRead, server.HandleAdminUsers))
+	http.HandleFunc("GET /admin/users/{id}", server.Require(auth.PermUsersRead, server.HandleAdminUser))
+	http.HandleFunc("POST /admin/users/{id}/suspend", server.Require(auth.PermUsersWrite, server.HandleAdminSuspendUser))
+	http.HandleFunc("POST /admin/users/{id}/activate", server.Require(auth.PermUsersWrite, server.HandleAdminActivateUser))
+	http.HandleFunc("POST /admin/users/{id}/deactivate", server.Require(auth.PermUsersWrite, server.HandleAdminDeactivateUser))
+	http.HandleFunc("POST /admin/users/{id}/reset-password", server.Require(auth.PermUsersWrite, server.HandleAdminForcePasswordReset))
+	http.HandleFunc("POST /admin/users/{id}/rename", server.Require(auth.PermUsersWrite, server.HandleAdminRenameUser))
+	http.HandleFunc("DELETE /admin/users/{id}", server.Require(auth.PermUsersWrite, server.HandleAdminDeleteUser))
 	http.HandleFunc("/logout", server.HandleLogout)
 
 	fmt.Println("=== セッション認証サーバー ===")
 	fmt.Println("http://localhost:3000 で起動中...")
+	fmt.Println("新規登録のハッシュ形式:", cfg.Hash.Algorithm)
+	fmt.Println("保存先:", cfg.Store.Backend)
+	if cfg.Email.Verify {
+		fmt.Println("メール確認: 有効（登録時に \"email\" が必要）")
+	}
 	fmt.Println()
 	fmt.Println("使い方 (02_session_server ディレクトリから実行):")
 	fmt.Println("  1. curl -X POST http://localhost:3000/register -d '{\"username\":\"testuser\",\"password\":\"secret123\"}'")
@@ -2,4 +2,14 @@ module jwt_server
 
 go 1.25.7
 
-require golang.org/x/crypto v0.48.0
+require github.com/shin6142/go-login/auth v0.0.0
+
+require (
+	github.com/mattn/go-sqlite3 v1.14.33 // indirect
+	github.com/oklog/ulid/v2 v2.1.1 // indirect
+	golang.org/x/crypto v0.48.0 // indirect
+	golang.org/x/sys v0.41.0 // indirect
+	golang.org/x/text v0.34.0 // indirect
+)
+
+replace github.com/shin6142/go-login/auth => ../../auth
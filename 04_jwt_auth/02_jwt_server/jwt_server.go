@@ -1,17 +1,13 @@
 package main
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
-	"strings"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/shin6142/go-login/auth"
 )
 
 // ===================
@@ -24,262 +20,93 @@ var secretKey = []byte("my-super-secret-key-12345")
 // トークンの有効期限
 const tokenExpiration = 1 * time.Hour
 
-// ===================
-// JWT関連
-// ===================
-
-type Header struct {
-	Alg string `json:"alg"`
-	Typ string `json:"typ"`
-}
-
-type Payload struct {
-	Username string `json:"username"`
-	Exp      int64  `json:"exp"`
-	Iat      int64  `json:"iat"`
-}
-
-func base64URLEncode(data []byte) string {
-	return base64.RawURLEncoding.EncodeToString(data)
-}
-
-func base64URLDecode(s string) ([]byte, error) {
-	return base64.RawURLEncoding.DecodeString(s)
-}
-
-func createSignature(header, payload string) string {
-	message := header + "." + payload
-	h := hmac.New(sha256.New, secretKey)
-	h.Write([]byte(message))
-	return base64URLEncode(h.Sum(nil))
-}
+// ユーザー管理・JWTの発行と検証・HTTPハンドラーは auth パッケージ（../../auth）にある。
+// auth.API に TokenIssuer を渡すと、ログイン時に JWT を返し、Bearer トークンで認証する。
 
-func generateJWT(username string) (string, error) {
-	header := Header{Alg: "HS256", Typ: "JWT"}
-	headerJSON, _ := json.Marshal(header)
-	headerEncoded := base64URLEncode(headerJSON)
-
-	now := time.Now()
-	payload := Payload{
-		Username: username,
-		Exp:      now.Add(tokenExpiration).Unix(),
-		Iat:      now.Unix(),
-	}
-	payloadJSON, _ := json.Marshal(payload)
-	payloadEncoded := base64URLEncode(payloadJSON)
-
-	signature := createSignature(headerEncoded, payloadEncoded)
-
-	return headerEncoded + "." + payloadEncoded + "." + signature, nil
-}
-
-func verifyJWT(token string) (*Payload, error) {
-	parts := strings.Split(token, ".")
-	if len(parts) != 3 {
-		return nil, fmt.Errorf("無効なトークン形式")
-	}
-
-	// 署名を検証
-	signatureExpected := createSignature(parts[0], parts[1])
-	if parts[2] != signatureExpected {
-		return nil, fmt.Errorf("署名が無効です")
-	}
-
-	// Payloadをデコード
-	payloadJSON, err := base64URLDecode(parts[1])
-	if err != nil {
-		return nil, fmt.Errorf("Payloadのデコードに失敗")
-	}
-
-	var payload Payload
-	if err := json.Unmarshal(payloadJSON, &payload); err != nil {
-		return nil, fmt.Errorf("Payloadのパースに失敗")
-	}
-
-	// 有効期限をチェック
-	if time.Now().Unix() > payload.Exp {
-		return nil, fmt.Errorf("トークンが期限切れです")
-	}
-
-	return &payload, nil
-}
-
-// ===================
-// ユーザー管理
-// ===================
-
-type User struct {
-	Username     string
-	PasswordHash []byte
-}
-
-type UserStore struct {
-	users map[string]*User
-}
-
-func NewUserStore() *UserStore {
-	return &UserStore{users: make(map[string]*User)}
-}
+func main() {
+	commands := auth.RegisterFlags(flag.CommandLine)
+	flag.Parse()
 
-func (s *UserStore) Register(username, password string) error {
-	if _, exists := s.users[username]; exists {
-		return fmt.Errorf("ユーザー '%s' は既に存在します", username)
-	}
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	cfg, err := auth.LoadConfig(auth.ConfigPath)
 	if err != nil {
-		return err
-	}
-	s.users[username] = &User{Username: username, PasswordHash: hash}
-	return nil
-}
-
-func (s *UserStore) Authenticate(username, password string) (*User, error) {
-	user, exists := s.users[username]
-	if !exists {
-		return nil, fmt.Errorf("ユーザーが見つかりません")
-	}
-	if err := bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)); err != nil {
-		return nil, fmt.Errorf("パスワードが間違っています")
-	}
-	return user, nil
-}
-
-// ===================
-// HTTPハンドラー
-// ===================
-
-type Server struct {
-	users *UserStore
-	// セッション方式と違い、セッションストアがない！
-}
-
-type AuthRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-}
-
-type LoginResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-	Token   string `json:"token,omitempty"` // JWTトークンを返す
-}
-
-type Response struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-}
-
-func jsonResponse(w http.ResponseWriter, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(data)
-}
-
-// Authorizationヘッダーからトークンを取得
-func extractToken(r *http.Request) (string, error) {
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		return "", fmt.Errorf("Authorizationヘッダーがありません")
-	}
-
-	// "Bearer <token>" の形式をパース
-	parts := strings.Split(authHeader, " ")
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		return "", fmt.Errorf("無効なAuthorizationヘッダー形式")
-	}
-
-	return parts[1], nil
-}
-
-// ユーザー登録
-func (s *Server) HandleRegister(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		jsonResponse(w, http.StatusMethodNotAllowed, Response{false, "POSTメソッドを使用してください"})
-		return
+		log.Fatal(err)
 	}
-
-	var req AuthRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		jsonResponse(w, http.StatusBadRequest, Response{false, "無効なJSONです"})
+	if done, err := commands.Run(cfg); done {
+		if err != nil {
+			log.Fatal(err)
+		}
 		return
 	}
 
-	if err := s.users.Register(req.Username, req.Password); err != nil {
-		jsonResponse(w, http.StatusConflict, Response{false, err.Error()})
-		return
-	}
-
-	log.Printf("ユーザー登録: %s", req.Username)
-	jsonResponse(w, http.StatusCreated, Response{true, "登録しました"})
-}
-
-// ログイン → JWTを発行
-func (s *Server) HandleLogin(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		jsonResponse(w, http.StatusMethodNotAllowed, Response{false, "POSTメソッドを使用してください"})
-		return
-	}
-
-	var req AuthRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		jsonResponse(w, http.StatusBadRequest, Response{false, "無効なJSONです"})
-		return
-	}
-
-	// パスワード認証
-	user, err := s.users.Authenticate(req.Username, req.Password)
+	// 保存先（config.json の store.backend: memory / sqlite）
+	backend, err := auth.OpenBackend(cfg.Store)
 	if err != nil {
-		jsonResponse(w, http.StatusUnauthorized, Response{false, err.Error()})
-		return
+		log.Fatal(err)
 	}
+	defer backend.Close()
 
-	// JWT生成（セッション方式と違い、サーバーに保存しない！）
-	token, err := generateJWT(user.Username)
+	users, pool, err := auth.NewUserStoreFromConfig(cfg, backend.Users)
 	if err != nil {
-		jsonResponse(w, http.StatusInternalServerError, Response{false, "トークン生成に失敗"})
-		return
+		log.Fatal(err)
 	}
-
-	log.Printf("ログイン成功: %s", user.Username)
-	jsonResponse(w, http.StatusOK, LoginResponse{
-		Success: true,
-		Message: fmt.Sprintf("ようこそ、%s さん！", user.Username),
-		Token:   token,
-	})
-}
-
-// プロフィール（認証が必要）
-func (s *Server) HandleProfile(w http.ResponseWriter, r *http.Request) {
-	// Authorizationヘッダーからトークンを取得
-	token, err := extractToken(r)
-	if err != nil {
-		jsonResponse(w, http.StatusUnauthorized, Response{false, err.Error()})
-		return
+	commands.ImportUsers(users, cfg)
+	// 管理者がいなければ作る（config.json の admin）
+	if err := auth.BootstrapAdmin(users, cfg.Admin); err != nil {
+		log.Fatal(err)
 	}
+	// 退会してから猶予期間（config.json の account.purge_after_days）が過ぎたアカウントを消す
+	stopPurger := auth.NewAccountPurgerFromConfig(cfg, users, nil, backend.OneTimeTokens, backend.LoginHistory).Start()
+	defer stopPurger()
 
-	// トークンを検証
-	payload, err := verifyJWT(token)
-	if err != nil {
-		jsonResponse(w, http.StatusUnauthorized, Response{false, err.Error()})
-		return
-	}
-
-	// 認証成功！
-	jsonResponse(w, http.StatusOK, Response{true, fmt.Sprintf("こんにちは、%s さん！", payload.Username)})
-}
+	throttle := auth.NewLoginThrottle(cfg.Lockout)
 
-func main() {
-	server := &Server{
-		users: NewUserStore(),
+	server := &auth.API{
+		Users:  users,
+		Tokens: auth.NewHS256Issuer(secretKey, tokenExpiration),
 		// セッションストアがない！ステートレス！
+		// config.json の email.verify が true なら、メールアドレスを確認するまでログインさせない
+		Verifier: auth.NewEmailVerifierFromConfig(cfg, users, backend.OneTimeTokens),
+		// メールアドレスを登録したユーザーは、/password/forgot でリセットリンクを受け取れる
+		Resetter: auth.NewPasswordResetterFromConfig(cfg, users, backend.OneTimeTokens, nil),
+		// 登録・再送・リセットのメールを裏で送る数と、同じアドレスへの間隔（config.json の email.send_workers など）
+		Mail: auth.NewMailQueueFromConfig(cfg.Email),
+		// アカウントごとのログイン試行の制限（config.json の lockout）
+		Throttle: throttle,
+		// ロールごとの権限（config.json の roles）
+		Roles: cfg.Roles,
+		// ログインの日時・接続元（/account/export で本人が見られる）
+		History: backend.LoginHistory,
 	}
 
 	http.HandleFunc("/register", server.HandleRegister)
 	http.HandleFunc("/login", server.HandleLogin)
-	http.HandleFunc("/profile", server.HandleProfile)
+	http.HandleFunc("/profile", server.Require(auth.PermProfileRead, server.HandleProfile))
+	http.HandleFunc("/password/change", server.Require(auth.PermPasswordChange, server.HandleChangePassword))
+	http.HandleFunc("GET /account/export", server.Require(auth.PermAccountExport, server.HandleAccountExport))
+	http.HandleFunc("POST /account/delete", server.Require(auth.PermAccountDelete, server.HandleAccountDelete))
+	http.HandleFunc("/email/verify", server.HandleVerifyEmail)
+	http.HandleFunc("/email/resend", server.HandleResendVerification)
+	http.HandleFunc("/password/forgot", server.HandleForgotPassword)
+	http.HandleFunc("/password/reset", server.HandleResetPassword)
+	http.HandleFunc("/stats/hashpool", server.Require(auth.PermStatsRead, pool.HandleStats))
+	http.HandleFunc("/admin/lockouts", server.Require(auth.PermLockoutsRead, throttle.HandleLockouts))
+	// ユーザー管理（admin ロール）
+	http.HandleFunc("GET /admin/users", server.Require(auth.PermUsersRead, server.HandleAdminUsers))
+	http.HandleFunc("GET /admin/users/{id}", server.Require(auth.PermUsersRead, server.HandleAdminUser))
+	http.HandleFunc("POST /admin/users/{id}/suspend", server.Require(auth.PermUsersWrite, server.HandleAdminSuspendUser))
+	http.HandleFunc("POST /admin/users/{id}/activate", server.Require(auth.PermUsersWrite, server.HandleAdminActivateUser))
+	http.HandleFunc("POST /admin/users/{id}/deactivate", server.Require(auth.PermUsersWrite, server.HandleAdminDeactivateUser))
+	http.HandleFunc("POST /admin/users/{id}/reset-password", server.Require(auth.PermUsersWrite, server.HandleAdminForcePasswordReset))
+	http.HandleFunc("POST /admin/users/{id}/rename", server.Require(auth.PermUsersWrite, server.HandleAdminRenameUser))
+	http.HandleFunc("DELETE /admin/users/{id}", server.Require(auth.PermUsersWrite, server.HandleAdminDeleteUser))
 
 	fmt.Println("=== JWT認証サーバー ===")
 	fmt.Println("http://localhost:3000 で起動中...")
+	fmt.Println("新規登録のハッシュ形式:", cfg.Hash.Algorithm)
+	fmt.Println("保存先:", cfg.Store.Backend)
+	if cfg.Email.Verify {
+		fmt.Println("メール確認: 有効（登録時に \"email\" が必要）")
+	}
 	fmt.Println()
 	fmt.Println("使い方 (04_jwt_auth ディレクトリから実行):")
 	fmt.Println("  1. curl -X POST http://localhost:3000/register -d '{\"username\":\"testuser\",\"password\":\"secret123\"}'")
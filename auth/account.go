@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// ===================
+// 本人による個人データのエクスポートとアカウントの削除
+// ===================
+
+// ログイン中のユーザーが、自分について保存されているもの（プロフィール・セッション・ログイン履歴・監査ログ）を
+// JSON でダウンロードし、パスワードを入れ直して自分のアカウントを削除する。
+//
+//	GET  /account/export
+//	POST /account/delete  {"password":"..."}
+
+// 有効な管理者が自分しかいないときに、自分を削除しようとした
+var ErrLastAdmin = errors.New("ほかに管理者がいないため削除できません。先に別のユーザーを管理者にしてください")
+
+// 本人がアカウントを削除する（セッションが盗まれていても、パスワードを知らなければ消せない）。
+// 管理者が戻せる猶予期間（退会）は置かず、すぐに消す。パスワードが違えば ErrPasswordMismatch。
+// 最後の管理者は消せない（ErrLastAdmin。管理者の API を使える人がいなくなる）
+func (s *UserStore) DeleteAccount(id, password string) error {
+	user, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	// 検証はロックの外で行う（ChangePassword と同じ理由）
+	if err := s.hasher.Verify(user.PasswordHash, password); err != nil {
+		if IsHashPoolBusy(err) {
+			return err
+		}
+		return ErrPasswordMismatch
+	}
+	s.selfDeleteMu.Lock()
+	defer s.selfDeleteMu.Unlock()
+	return s.deleteIf(id, ActorSelf, "本人が削除", func(current *User) error {
+		// 検証した後にパスワードが変わっていたら、確かめたのは古いパスワード
+		if !current.PasswordChangedAt.Equal(user.PasswordChangedAt) {
+			return ErrPasswordChanged
+		}
+		return s.checkNotLastAdmin(current)
+	})
+}
+
+// 管理者の API でも自分自身は削除・停止できない（checkNotSelf）ので、管理者がいなくなるのは本人による削除だけ
+func (s *UserStore) checkNotLastAdmin(user *User) error {
+	if user.State != StateActive || !slices.Contains(user.Roles, RoleAdmin) {
+		return nil
+	}
+	_, admins, err := s.repo.List(UserQuery{State: StateActive, Role: RoleAdmin})
+	if err != nil {
+		return err
+	}
+	if admins <= 1 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// ユーザーを消した後に、ユーザーとは別に持っているもの（セッション・使い捨てトークン・ログイン履歴）を消す。
+// SQLite は外部キーで一緒に消えるが、インメモリは別々に持っているので消しておく
+func (a *API) deleteUserData(user *User) {
+	a.deleteSessions(user)
+	if a.Verifier != nil {
+		a.Verifier.tokens.DeleteUser(user.ID, TokenPurposeVerifyEmail)
+	}
+	if a.Resetter != nil {
+		a.Resetter.tokens.DeleteUser(user.ID, TokenPurposeResetPassword)
+	}
+	if a.History != nil {
+		if err := a.History.DeleteUser(user.ID); err != nil {
+			log.Printf("ログイン履歴の削除に失敗: %s: %v", user.Username, err)
+		}
+	}
+}
+
+// --- HTTPハンドラー ---
+
+// エクスポートする個人データ
+type AccountExport struct {
+	ExportedAt   time.Time       `json:"exported_at"`
+	Profile      AdminUser       `json:"profile"` // 管理者に見せるのと同じ項目（パスワードハッシュは出さない）
+	Sessions     []ExportSession `json:"sessions"`
+	LoginHistory []LoginEvent    `json:"login_history"` // 新しい順
+	AuditEvents  []AuditEvent    `json:"audit_events"`  // 古い順
+}
+
+// セッションID はそれだけでログインできるので出さない
+type ExportSession struct {
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Current   bool      `json:"current"` // このリクエストのセッション
+}
+
+// 個人データを JSON ファイルとしてダウンロードさせる
+func (a *API) HandleAccountExport(w http.ResponseWriter, r *http.Request) {
+	user, err := a.CurrentUser(r)
+	if err != nil {
+		JSONResponse(w, http.StatusUnauthorized, Response{false, err.Error()})
+		return
+	}
+
+	export := AccountExport{
+		ExportedAt:   time.Now(),
+		Profile:      newAdminUser(user),
+		Sessions:     []ExportSession{},
+		LoginHistory: []LoginEvent{},
+	}
+	if a.Sessions != nil {
+		sessions, err := a.Sessions.ListUser(user.ID)
+		if err != nil {
+			exportErrorResponse(w, user, "セッション", err)
+			return
+		}
+		cookie, _ := r.Cookie(sessionCookieName)
+		for _, s := range sessions {
+			export.Sessions = append(export.Sessions, ExportSession{
+				CreatedAt: s.CreatedAt,
+				ExpiresAt: s.ExpiresAt,
+				Current:   cookie != nil && cookie.Value == s.ID,
+			})
+		}
+	}
+	if a.History != nil {
+		if export.LoginHistory, err = a.History.List(user.ID); err != nil {
+			exportErrorResponse(w, user, "ログイン履歴", err)
+			return
+		}
+	}
+	if export.AuditEvents, err = a.Users.AuditEvents(user.ID); err != nil {
+		exportErrorResponse(w, user, "監査ログ", err)
+		return
+	}
+
+	log.Printf("個人データをエクスポート: %s", user.Username)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="account-%s.json"`, user.ID))
+	JSONResponse(w, http.StatusOK, export)
+}
+
+func exportErrorResponse(w http.ResponseWriter, user *User, what string, err error) {
+	log.Printf("個人データのエクスポートに失敗（%s）: %s: %v", what, user.Username, err)
+	JSONResponse(w, http.StatusInternalServerError, Response{false, "個人データを取得できませんでした"})
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// 自分のアカウントを削除する。セッション・使い捨てトークン・ログイン履歴も消し、Cookie も消す
+func (a *API) HandleAccountDelete(w http.ResponseWriter, r *http.Request) {
+	user, err := a.CurrentUser(r)
+	if err != nil {
+		JSONResponse(w, http.StatusUnauthorized, Response{false, err.Error()})
+		return
+	}
+	if !requireJSON(w, r) {
+		return
+	}
+	var req DeleteAccountRequest
+	if err := decodeJSON(r, &req); err != nil || req.Password == "" {
+		JSONResponse(w, http.StatusBadRequest, Response{false, "パスワードを入力してください"})
+		return
+	}
+
+	// セッションを盗んだ人がここでパスワードを試せないよう、ログインと合わせて数える
+	if !a.beginAttempt(w, user.Username) {
+		return
+	}
+	err = a.Users.DeleteAccount(user.ID, req.Password)
+	a.finishAttempt(user.Username, err, ErrPasswordMismatch)
+	if err != nil {
+		if overloadedResponse(w, err) {
+			return
+		}
+		if errors.Is(err, ErrPasswordMismatch) {
+			JSONResponse(w, http.StatusUnauthorized, Response{false, "パスワードが間違っています"})
+			return
+		}
+		if errors.Is(err, ErrUserNotFound) {
+			JSONResponse(w, http.StatusOK, Response{true, "アカウントは既に削除されています"})
+			return
+		}
+		if errors.Is(err, ErrPasswordChanged) || errors.Is(err, ErrLastAdmin) {
+			JSONResponse(w, http.StatusConflict, Response{false, err.Error()})
+			return
+		}
+		log.Printf("アカウントの削除に失敗: %s: %v", user.Username, err)
+		JSONResponse(w, http.StatusInternalServerError, Response{false, "アカウントを削除できませんでした"})
+		return
+	}
+
+	a.deleteUserData(user)
+	clearSessionCookie(w)
+	log.Printf("アカウントを削除: %s（本人による）", user.Username)
+	JSONResponse(w, http.StatusOK, Response{true, "アカウントを削除しました"})
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 最後の管理者は自分を削除できない。ほかに管理者がいれば削除できる
+func TestDeleteAccountKeepsLastAdmin(t *testing.T) {
+	repos := map[string]func(t *testing.T) UserRepository{
+		"memory": func(t *testing.T) UserRepository { return NewMemoryUserRepository() },
+		"sqlite": func(t *testing.T) UserRepository {
+			db, err := OpenSQLite(filepath.Join(t.TempDir(), "account.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { db.Close() })
+			return NewSQLiteUserRepository(db)
+		},
+	}
+	for name, newRepo := range repos {
+		t.Run(name, func(t *testing.T) {
+			users, err := NewUserStore(newRepo(t), NewBcryptHasher(bcrypt.MinCost, false), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			admin := registerWithRoles(t, users, "admin", RoleUser, RoleAdmin)
+
+			if err := users.DeleteAccount(admin.ID, "password-admin"); !errors.Is(err, ErrLastAdmin) {
+				t.Fatalf("最後の管理者の削除で err = %v, want ErrLastAdmin", err)
+			}
+			// 管理者でないユーザーは関係なく削除できる
+			user := registerWithRoles(t, users, "taro", RoleUser)
+			if err := users.DeleteAccount(user.ID, "password-taro"); err != nil {
+				t.Fatalf("一般ユーザーを削除できない: %v", err)
+			}
+
+			registerWithRoles(t, users, "hanako", RoleUser, RoleAdmin)
+			if err := users.DeleteAccount(admin.ID, "password-admin"); err != nil {
+				t.Fatalf("ほかに管理者がいるのに削除できない: %v", err)
+			}
+		})
+	}
+}
+
+func registerWithRoles(t *testing.T, users *UserStore, username string, roles ...string) *User {
+	t.Helper()
+	if err := users.Register(username, username+"@example.com", "password-"+username); err != nil {
+		t.Fatal(err)
+	}
+	user, err := users.Get(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetRoles(user.ID, roles); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
@@ -0,0 +1,355 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ===================
+// ユーザー管理（管理者向け）
+// ===================
+
+// 管理者がユーザーを一覧・検索し、停止・有効化・退会・パスワードリセットの強制・削除をする。
+// ルートは users:read / users:write の権限で守る（Require）。
+//
+//	GET    /admin/users?q=taro&state=suspended&page=1&per_page=20
+//	GET    /admin/users/{id}
+//	POST   /admin/users/{id}/suspend
+//	POST   /admin/users/{id}/activate
+//	POST   /admin/users/{id}/deactivate
+//	POST   /admin/users/{id}/reset-password
+//	POST   /admin/users/{id}/rename
+//	DELETE /admin/users/{id}
+//
+// ユーザーはユーザー名ではなく ID で指す（名前は変えられるので、操作の途中で別人を指さないように）。
+// 停止・退会・リセットの強制では、そのユーザーのセッションをすぐに消す。
+// JWT は消せないが、CurrentUser がリクエストごとにユーザーを読み直して弾く。
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+	maxPage        = 100000 // (page-1)*per_page が桁あふれしないように。これより先を見ることはない
+)
+
+var ErrCannotModifySelf = errors.New("自分自身のアカウントは停止・退会・削除できません")
+
+// --- UserStore ---
+
+// ユーザー一覧
+func (s *UserStore) List(query UserQuery) ([]*User, int, error) {
+	return s.repo.List(query)
+}
+
+// 次のログインまでにパスワードのリセットを求める（漏洩が疑われるときなど）
+func (s *UserStore) RequirePasswordReset(id string) (*User, error) {
+	return s.modify(id, func(user *User) {
+		user.PasswordResetRequired = true
+	})
+}
+
+// 完全に削除する（元に戻せない。戻せるようにするなら deactivated にする）
+func (s *UserStore) Delete(id, actor, reason string) error {
+	return s.deleteIf(id, actor, reason, func(*User) error { return nil })
+}
+
+// 削除ジョブが、消す直前に状態を確かめ直すため
+var errSkipDelete = errors.New("削除の条件を満たしていません")
+
+// ロックを取ってから check で確かめ、エラーがなければ消す
+func (s *UserStore) deleteIf(id, actor, reason string, check func(user *User) error) error {
+	user, unlock, err := s.lockUser(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := check(user); err != nil {
+		return err
+	}
+	if err := s.repo.Delete(id); err != nil {
+		return err
+	}
+	s.recordAudit(AuditEvent{Type: AuditDeleted, UserID: user.ID, Username: user.Username,
+		Actor: actor, From: user.State, Reason: reason})
+	return nil
+}
+
+// --- HTTPハンドラー ---
+
+// 管理者に見せるユーザーの情報（パスワードハッシュは出さない）
+type AdminUser struct {
+	ID                    string       `json:"id"`
+	Username              string       `json:"username"`
+	DisplayName           string       `json:"display_name"`
+	Email                 string       `json:"email,omitempty"`
+	EmailVerified         bool         `json:"email_verified"`
+	Roles                 []string     `json:"roles"`
+	State                 AccountState `json:"state"`
+	StateChangedAt        time.Time    `json:"state_changed_at"`
+	PasswordResetRequired bool         `json:"password_reset_required"`
+	PasswordChangedAt     time.Time    `json:"password_changed_at"`
+}
+
+func newAdminUser(u *User) AdminUser {
+	au := AdminUser{
+		ID:                    u.ID,
+		Username:              u.Username,
+		DisplayName:           u.DisplayName,
+		Email:                 u.Email,
+		EmailVerified:         !u.EmailVerifiedAt.IsZero(),
+		Roles:                 u.Roles,
+		State:                 u.State,
+		StateChangedAt:        u.StateChangedAt,
+		PasswordResetRequired: u.PasswordResetRequired,
+		PasswordChangedAt:     u.PasswordChangedAt,
+	}
+	if au.Roles == nil {
+		au.Roles = []string{}
+	}
+	return au
+}
+
+type AdminUserListResponse struct {
+	Users   []AdminUser `json:"users"`
+	Total   int         `json:"total"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+}
+
+type AdminUserResponse struct {
+	Success  bool      `json:"success"`
+	Message  string    `json:"message"`
+	User     AdminUser `json:"user"`
+	MailSent bool      `json:"mail_sent,omitempty"` // リセットの強制でリセットメールを送ったか
+}
+
+// ユーザー一覧（検索・ページ分け）
+func (a *API) HandleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	page, err := queryInt(r, "page", 1)
+	if err != nil || page < 1 || page > maxPage {
+		JSONResponse(w, http.StatusBadRequest, Response{false, fmt.Sprintf("page は1〜%d の整数で指定してください", maxPage)})
+		return
+	}
+	perPage, err := queryInt(r, "per_page", defaultPerPage)
+	if err != nil || perPage < 1 {
+		JSONResponse(w, http.StatusBadRequest, Response{false, "per_page は1以上の整数で指定してください"})
+		return
+	}
+	perPage = min(perPage, maxPerPage) // 多すぎるときは上限にそろえる（返事の per_page でわかる）
+
+	state := AccountState(r.URL.Query().Get("state"))
+	if _, ok := stateTransitions[state]; state != "" && !ok {
+		JSONResponse(w, http.StatusBadRequest, Response{false, "state は pending / active / suspended / deactivated のどれかで指定してください"})
+		return
+	}
+
+	query := UserQuery{
+		Search: r.URL.Query().Get("q"),
+		State:  state,
+		Offset: (page - 1) * perPage,
+		Limit:  perPage,
+	}
+	users, total, err := a.Users.List(query)
+	if err != nil {
+		log.Printf("ユーザー一覧の取得に失敗: %v", err)
+		JSONResponse(w, http.StatusInternalServerError, Response{false, "ユーザー一覧を取得できませんでした"})
+		return
+	}
+	list := make([]AdminUser, len(users))
+	for i, u := range users {
+		list[i] = newAdminUser(u)
+	}
+	JSONResponse(w, http.StatusOK, AdminUserListResponse{Users: list, Total: total, Page: page, PerPage: perPage})
+}
+
+// クエリパラメータの整数（なければ def）
+func queryInt(r *http.Request, key string, def int) (int, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// 1人の詳細
+func (a *API) HandleAdminUser(w http.ResponseWriter, r *http.Request) {
+	user, err := a.Users.GetByID(r.PathValue("id"))
+	if err != nil {
+		adminErrorResponse(w, err)
+		return
+	}
+	JSONResponse(w, http.StatusOK, AdminUserResponse{Success: true, Message: "ok", User: newAdminUser(user)})
+}
+
+// 停止する。ログインできなくなり、ログイン中のセッションも消える
+func (a *API) HandleAdminSuspendUser(w http.ResponseWriter, r *http.Request) {
+	a.transitionUser(w, r, StateSuspended, "アカウントを停止しました")
+}
+
+// 使える状態に戻す（停止の解除・猶予期間中の退会の取り消し・メールアドレスの確認を待たずに有効化）
+func (a *API) HandleAdminActivateUser(w http.ResponseWriter, r *http.Request) {
+	a.transitionUser(w, r, StateActive, "アカウントを有効にしました")
+}
+
+// 退会させる。猶予期間（account.purge_after_days）が過ぎると削除ジョブが完全に消す
+func (a *API) HandleAdminDeactivateUser(w http.ResponseWriter, r *http.Request) {
+	a.transitionUser(w, r, StateDeactivated, "アカウントを退会させました")
+}
+
+// 状態を変える。理由はボディの reason（任意）で受け取り、監査ログに残す
+func (a *API) transitionUser(w http.ResponseWriter, r *http.Request, to AccountState, message string) {
+	id := r.PathValue("id")
+	if to != StateActive {
+		if err := checkNotSelf(r, id); err != nil {
+			JSONResponse(w, http.StatusBadRequest, Response{false, err.Error()})
+			return
+		}
+	}
+	if !requireJSON(w, r) {
+		return
+	}
+	var req TransitionRequest
+	if err := decodeJSON(r, &req); err != nil {
+		JSONResponse(w, http.StatusBadRequest, Response{false, "無効なリクエストです"})
+		return
+	}
+
+	user, err := a.Users.Transition(id, to, adminActor(r), req.Reason)
+	if errors.Is(err, ErrInvalidTransition) {
+		JSONResponse(w, http.StatusConflict, Response{false, err.Error()})
+		return
+	}
+	if err != nil {
+		adminErrorResponse(w, err)
+		return
+	}
+	if to != StateActive {
+		a.deleteSessions(user)
+	}
+	log.Printf("アカウントの状態を変更: %s → %s（%s による）", user.Username, to, adminName(r))
+	JSONResponse(w, http.StatusOK, AdminUserResponse{Success: true, Message: message, User: newAdminUser(user)})
+}
+
+type TransitionRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// パスワードのリセットを強制する。セッションを消し、メールアドレスがあればリセットリンクを送る
+// （なければ管理者が別の手段で本人に /password/forgot を案内する）
+func (a *API) HandleAdminForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+	user, err := a.Users.RequirePasswordReset(r.PathValue("id"))
+	if err != nil {
+		adminErrorResponse(w, err)
+		return
+	}
+	a.deleteSessions(user)
+	log.Printf("パスワードのリセットを要求: %s（%s による）", user.Username, adminName(r))
+
+	resp := AdminUserResponse{Success: true, Message: "パスワードのリセットを要求しました", User: newAdminUser(user)}
+	if a.Resetter != nil && user.Email != "" {
+		if err := a.Resetter.Forgot(user.Email); err != nil {
+			log.Printf("リセットメールの送信に失敗: %s: %v", user.Username, err)
+		} else {
+			resp.MailSent = true
+		}
+	}
+	JSONResponse(w, http.StatusOK, resp)
+}
+
+// ユーザー名を変える（ID は変わらないので、ログイン中のセッション・トークンはそのまま使える）
+func (a *API) HandleAdminRenameUser(w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+	var req RenameRequest
+	if err := decodeJSON(r, &req); err != nil {
+		JSONResponse(w, http.StatusBadRequest, Response{false, "無効なリクエストです"})
+		return
+	}
+	user, err := a.Users.Rename(r.PathValue("id"), req.Username)
+	if errors.Is(err, ErrInvalidUsername) || errors.Is(err, ErrUserExists) {
+		JSONResponse(w, http.StatusBadRequest, Response{false, err.Error()})
+		return
+	}
+	if err != nil {
+		adminErrorResponse(w, err)
+		return
+	}
+	JSONResponse(w, http.StatusOK, AdminUserResponse{Success: true, Message: "ユーザー名を変更しました", User: newAdminUser(user)})
+}
+
+type RenameRequest struct {
+	Username string `json:"username"`
+}
+
+// アカウントを削除する（セッション・使い捨てトークンも消す）
+func (a *API) HandleAdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := checkNotSelf(r, id); err != nil {
+		JSONResponse(w, http.StatusBadRequest, Response{false, err.Error()})
+		return
+	}
+	user, err := a.Users.GetByID(id)
+	if err != nil {
+		adminErrorResponse(w, err)
+		return
+	}
+	if err := a.Users.Delete(user.ID, adminActor(r), "管理者が削除"); err != nil {
+		adminErrorResponse(w, err)
+		return
+	}
+
+	a.deleteUserData(user)
+
+	log.Printf("アカウントを削除: %s（%s による）", user.Username, adminName(r))
+	JSONResponse(w, http.StatusOK, Response{true, "アカウントを削除しました"})
+}
+
+// 自分を停止・退会・削除すると、管理者がいなくなることがある
+func checkNotSelf(r *http.Request, id string) error {
+	if admin := UserFromContext(r.Context()); admin != nil && admin.ID == id {
+		return ErrCannotModifySelf
+	}
+	return nil
+}
+
+func (a *API) deleteSessions(user *User) {
+	if a.Sessions == nil {
+		return
+	}
+	if n, err := a.Sessions.DeleteUser(user.ID); err != nil {
+		log.Printf("セッションの削除に失敗: %s: %v", user.Username, err)
+	} else if n > 0 {
+		log.Printf("セッションを %d 件削除: %s", n, user.Username)
+	}
+}
+
+// 監査ログの actor（管理者のユーザーID）
+func adminActor(r *http.Request) string {
+	if admin := UserFromContext(r.Context()); admin != nil {
+		return admin.ID
+	}
+	return "unknown"
+}
+
+func adminName(r *http.Request) string {
+	if admin := UserFromContext(r.Context()); admin != nil {
+		return admin.Username
+	}
+	return "不明"
+}
+
+func adminErrorResponse(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrUserNotFound) {
+		JSONResponse(w, http.StatusNotFound, Response{false, err.Error()})
+		return
+	}
+	log.Printf("ユーザー管理の操作に失敗: %v", err)
+	JSONResponse(w, http.StatusInternalServerError, Response{false, "操作に失敗しました"})
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// page が大きすぎれば 400（オフセットの計算が桁あふれしないように）、per_page は上限にそろえる
+func TestAdminUsersPagination(t *testing.T) {
+	api := &API{Users: newTestStore(t, NewBcryptHasher(bcrypt.MinCost, false))}
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		api.HandleAdminUsers(rec, httptest.NewRequest(http.MethodGet, "/admin/users?"+query, nil))
+		return rec
+	}
+
+	for _, page := range []string{"0", strconv.Itoa(maxPage + 1), "9223372036854775807"} {
+		if rec := get("page=" + page); rec.Code != http.StatusBadRequest {
+			t.Errorf("page=%s: status = %d, want 400", page, rec.Code)
+		}
+	}
+
+	rec := get("page=" + strconv.Itoa(maxPage) + "&per_page=9223372036854775807")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var resp AdminUserListResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.PerPage != maxPerPage || len(resp.Users) != 0 {
+		t.Errorf("per_page = %d, users = %d, want %d / 0", resp.PerPage, len(resp.Users), maxPerPage)
+	}
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ===================
+// 監査ログ
+// ===================
+
+// アカウントの状態の変化（停止・退会・削除など）を「いつ・誰が・何を」の形で残す。
+// 後から「なぜこのアカウントが消えたのか」を調べられるように、通常のログとは別に書き出す。
+//
+//	{"time":"...","type":"state_changed","user_id":"01K7...","username":"taro","actor":"01K6...","from":"active","to":"suspended"}
+
+const (
+	AuditStateChanged = "state_changed" // 状態が変わった
+	AuditDeleted      = "deleted"       // 完全に削除した
+)
+
+// 操作した人（Actor）。管理者が操作したときは、その管理者のユーザーID
+const (
+	ActorSelf   = "self"   // 本人（メールアドレスの確認など）
+	ActorSystem = "system" // 削除ジョブなど
+)
+
+type AuditEvent struct {
+	Time     time.Time    `json:"time"`
+	Type     string       `json:"type"`
+	UserID   string       `json:"user_id"`
+	Username string       `json:"username"`
+	Actor    string       `json:"actor"`
+	From     AccountState `json:"from,omitempty"`
+	To       AccountState `json:"to,omitempty"`
+	Reason   string       `json:"reason,omitempty"`
+}
+
+// AuditLog は監査ログの書き出し先。
+// アカウントを消しても、そのユーザーのイベントは消さない（後から削除の経緯を調べられるように）
+type AuditLog interface {
+	Record(event AuditEvent) error
+	Events(userID string) ([]AuditEvent, error) // そのユーザーのイベント（古い順）
+}
+
+// 通常のログに出す（account.audit_log が空のとき）
+type stdAuditLog struct{}
+
+func (stdAuditLog) Record(e AuditEvent) error {
+	log.Printf("監査: %s %s(%s) %s→%s by %s %s", e.Type, e.Username, e.UserID, e.From, e.To, e.Actor, e.Reason)
+	return nil
+}
+
+// 通常のログからは読み戻せない
+func (stdAuditLog) Events(string) ([]AuditEvent, error) {
+	return []AuditEvent{}, nil
+}
+
+// ファイルに1行1件の JSON で追記する（JSON Lines。jq などでそのまま読める）
+type FileAuditLog struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func NewFileAuditLog(path string) (*FileAuditLog, error) {
+	// 書き換えられないように、追記だけで開く
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("監査ログを開けません: %w", err)
+	}
+	return &FileAuditLog{path: path, file: f}, nil
+}
+
+func (l *FileAuditLog) Record(event AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.file.Write(append(line, '\n'))
+	return err
+}
+
+// ファイルを頭から読んで、そのユーザーの行だけを返す（エクスポートのときしか読まないので索引は持たない）
+func (l *FileAuditLog) Events(userID string) ([]AuditEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("監査ログを開けません: %w", err)
+	}
+	defer f.Close()
+
+	events := []AuditEvent{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue // 書きかけで止まった行など
+		}
+		if event.UserID == userID {
+			events = append(events, event)
+		}
+	}
+	return events, scanner.Err()
+}
+
+func (l *FileAuditLog) Close() error {
+	return l.file.Close()
+}
+
+// そのユーザーの監査ログ
+func (s *UserStore) AuditEvents(id string) ([]AuditEvent, error) {
+	return s.audit.Events(id)
+}
+
+// 監査ログを書く。書けなくても操作自体は取り消さない（ログに残して続ける）
+func (s *UserStore) recordAudit(event AuditEvent) {
+	event.Time = time.Now()
+	if err := s.audit.Record(event); err != nil {
+		log.Printf("監査ログの書き込みに失敗: %v: %+v", err, event)
+	}
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// ===================
+// 保存先の切り替え
+// ===================
+
+// 設定で選んだ保存先（ユーザー・セッション・使い捨てトークン・ログイン履歴）
+type Backend struct {
+	Users         UserRepository
+	Sessions      SessionRepository
+	OneTimeTokens OneTimeTokenRepository
+	LoginHistory  LoginHistoryRepository
+	db            *sql.DB // SQLite のときだけ
+}
+
+func OpenBackend(cfg StoreConfig) (*Backend, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return &Backend{
+			Users:         NewMemoryUserRepository(),
+			Sessions:      NewSessionStore(),
+			OneTimeTokens: NewMemoryOneTimeTokenRepository(),
+			LoginHistory:  NewMemoryLoginHistoryRepository(),
+		}, nil
+	case "sqlite":
+		db, err := OpenSQLite(cfg.SQLitePath)
+		if err != nil {
+			return nil, fmt.Errorf("SQLite（%s）を開けません: %w", cfg.SQLitePath, err)
+		}
+		return &Backend{
+			Users:         NewSQLiteUserRepository(db),
+			Sessions:      NewSQLiteSessionRepository(db),
+			OneTimeTokens: NewSQLiteOneTimeTokenRepository(db),
+			LoginHistory:  NewSQLiteLoginHistoryRepository(db),
+			db:            db,
+		}, nil
+	default:
+		return nil, fmt.Errorf("未対応の保存先です: %s（memory / sqlite）", cfg.Backend)
+	}
+}
+
+func (b *Backend) Close() error {
+	if b.db == nil {
+		return nil
+	}
+	return b.db.Close()
+}
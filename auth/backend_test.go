@@ -0,0 +1,322 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// 同じテストをインメモリと SQLite（一時ファイル）の両方で回す
+func forEachBackend(t *testing.T, f func(t *testing.T, b *Backend)) {
+	for _, name := range []string{"memory", "sqlite"} {
+		t.Run(name, func(t *testing.T) {
+			b, err := OpenBackend(StoreConfig{Backend: name, SQLitePath: filepath.Join(t.TempDir(), "auth.db")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { b.Close() })
+			f(t, b)
+		})
+	}
+}
+
+func newRepoUser(username, email string) *User {
+	now := time.Now().Truncate(time.Millisecond)
+	return &User{
+		ID:                newUserID(),
+		Username:          username,
+		DisplayName:       username,
+		PasswordHash:      []byte("$2a$04$hash-of-" + username),
+		PasswordChangedAt: now,
+		Email:             email,
+		Roles:             []string{RoleUser},
+		State:             StateActive,
+		StateChangedAt:    now,
+	}
+}
+
+func TestUserRepositoryCRUD(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b *Backend) {
+		repo := b.Users
+		taro := newRepoUser("taro", "taro@example.com")
+		if err := repo.Create(taro); err != nil {
+			t.Fatal(err)
+		}
+
+		for name, get := range map[string]func() (*User, error){
+			"Get":        func() (*User, error) { return repo.Get("taro") },
+			"GetByID":    func() (*User, error) { return repo.GetByID(taro.ID) },
+			"GetByEmail": func() (*User, error) { return repo.GetByEmail("taro@example.com") },
+		} {
+			got, err := get()
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if got.ID != taro.ID || got.Username != "taro" || !bytes.Equal(got.PasswordHash, taro.PasswordHash) {
+				t.Errorf("%s = %+v", name, got)
+			}
+		}
+		for name, get := range map[string]func() (*User, error){
+			"Get":        func() (*User, error) { return repo.Get("nobody") },
+			"GetByID":    func() (*User, error) { return repo.GetByID(newUserID()) },
+			"GetByEmail": func() (*User, error) { return repo.GetByEmail("nobody@example.com") },
+		} {
+			if _, err := get(); !errors.Is(err, ErrUserNotFound) {
+				t.Errorf("%s（いない）: err = %v, want ErrUserNotFound", name, err)
+			}
+		}
+
+		// 名前もアドレスも使われていれば、名前の重複として扱う
+		if err := repo.Create(newRepoUser("taro", "other@example.com")); !errors.Is(err, ErrUserExists) {
+			t.Errorf("同じ名前: err = %v, want ErrUserExists", err)
+		}
+		if err := repo.Create(newRepoUser("hanako", "taro@example.com")); !errors.Is(err, ErrEmailExists) {
+			t.Errorf("同じアドレス: err = %v, want ErrEmailExists", err)
+		}
+
+		// 全ての項目が書き戻される
+		user, err := repo.GetByID(taro.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user.PasswordHistory = [][]byte{user.PasswordHash}
+		user.PasswordHash = []byte("$2a$04$new-hash")
+		user.PasswordVersion = 2
+		user.Roles = []string{RoleUser, RoleAdmin}
+		user.State = StateSuspended
+		user.EmailVerifiedAt = time.Now().Truncate(time.Millisecond)
+		user.PasswordResetRequired = true
+		if err := repo.Update(user); err != nil {
+			t.Fatal(err)
+		}
+		got, err := repo.GetByID(taro.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.PasswordHash, user.PasswordHash) || len(got.PasswordHistory) != 1 ||
+			got.PasswordVersion != 2 || !slices.Equal(slices.Sorted(slices.Values(got.Roles)), []string{RoleAdmin, RoleUser}) || got.State != StateSuspended ||
+			!got.EmailVerifiedAt.Equal(user.EmailVerifiedAt) || !got.PasswordResetRequired {
+			t.Errorf("Update が反映されていない: %+v", got)
+		}
+
+		// 返したユーザーを書き換えても、保存されたものは変わらない
+		for i := range got.Roles {
+			got.Roles[i] = "tampered"
+		}
+		got.PasswordHash[0] = 'X'
+		got.PasswordHistory[0][0] = 'X'
+		if again, _ := repo.GetByID(taro.ID); !slices.Contains(again.Roles, RoleUser) ||
+			again.PasswordHash[0] != '$' || again.PasswordHistory[0][0] != '$' {
+			t.Error("返したユーザーが保存されたものと共有されている")
+		}
+
+		if err := repo.Update(newRepoUser("ghost", "")); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("いないユーザーの Update: err = %v, want ErrUserNotFound", err)
+		}
+
+		// 名前の変更。使われている名前には変えられない
+		hanako := newRepoUser("hanako", "")
+		if err := repo.Create(hanako); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Rename(taro.ID, "hanako", "Hanako"); !errors.Is(err, ErrUserExists) {
+			t.Errorf("使われている名前への変更: err = %v, want ErrUserExists", err)
+		}
+		if err := repo.Rename(taro.ID, "jiro", "Jiro"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.Get("taro"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("古い名前で見つかる: %v", err)
+		}
+		if got, err := repo.Get("jiro"); err != nil || got.ID != taro.ID || got.DisplayName != "Jiro" {
+			t.Errorf("新しい名前: %+v, %v", got, err)
+		}
+
+		if err := repo.Delete(taro.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.GetByID(taro.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("削除したユーザーが見つかる: %v", err)
+		}
+		if err := repo.Delete(taro.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("2回目の Delete: err = %v, want ErrUserNotFound", err)
+		}
+		// 消したユーザーのアドレスは、別の人が使える
+		if err := repo.Create(newRepoUser("saburo", "taro@example.com")); err != nil {
+			t.Errorf("削除したユーザーのアドレスが使えない: %v", err)
+		}
+	})
+}
+
+func TestSessionRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b *Backend) {
+		taro, hanako := newRepoUser("taro", ""), newRepoUser("hanako", "")
+		for _, u := range []*User{taro, hanako} {
+			if err := b.Users.Create(u); err != nil {
+				t.Fatal(err)
+			}
+		}
+		first, err := b.Sessions.Create(taro.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.Sessions.Create(taro.ID); err != nil {
+			t.Fatal(err)
+		}
+		other, err := b.Sessions.Create(hanako.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := b.Sessions.Get(first.ID)
+		if err != nil || got.UserID != taro.ID {
+			t.Fatalf("Get = %+v, %v", got, err)
+		}
+		if sessions, err := b.Sessions.ListUser(taro.ID); err != nil || len(sessions) != 2 || sessions[0].ID != first.ID {
+			t.Errorf("ListUser = %v, %v（作った順に2つ）", sessions, err)
+		}
+
+		b.Sessions.Delete(first.ID)
+		if _, err := b.Sessions.Get(first.ID); err == nil {
+			t.Error("削除したセッションが使える")
+		}
+		if n, err := b.Sessions.DeleteUser(taro.ID); err != nil || n != 1 {
+			t.Errorf("DeleteUser = %d, %v, want 1", n, err)
+		}
+		// 別のユーザーのセッションは残る
+		if _, err := b.Sessions.Get(other.ID); err != nil {
+			t.Errorf("別のユーザーのセッションが消えた: %v", err)
+		}
+	})
+}
+
+func TestOneTimeTokenRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b *Backend) {
+		taro := newRepoUser("taro", "")
+		if err := b.Users.Create(taro); err != nil {
+			t.Fatal(err)
+		}
+		token := &OneTimeToken{Hash: hashToken("raw-token"), Purpose: TokenPurposeVerifyEmail, UserID: taro.ID, ExpiresAt: time.Now().Add(time.Hour)}
+		if err := b.OneTimeTokens.Create(token); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := b.OneTimeTokens.Consume(token.Hash, TokenPurposeResetPassword); !errors.Is(err, ErrTokenInvalid) {
+			t.Errorf("別の用途で使えた: %v", err)
+		}
+		got, err := b.OneTimeTokens.Consume(token.Hash, TokenPurposeVerifyEmail)
+		if err != nil || got.UserID != taro.ID {
+			t.Fatalf("Consume = %+v, %v", got, err)
+		}
+		if _, err := b.OneTimeTokens.Consume(token.Hash, TokenPurposeVerifyEmail); !errors.Is(err, ErrTokenInvalid) {
+			t.Errorf("2回使えた: %v", err)
+		}
+
+		// DeleteUser はその用途のものだけを消す
+		reset := &OneTimeToken{Hash: hashToken("reset"), Purpose: TokenPurposeResetPassword, UserID: taro.ID, ExpiresAt: time.Now().Add(time.Hour)}
+		verify := &OneTimeToken{Hash: hashToken("verify"), Purpose: TokenPurposeVerifyEmail, UserID: taro.ID, ExpiresAt: time.Now().Add(time.Hour)}
+		for _, tok := range []*OneTimeToken{reset, verify} {
+			if err := b.OneTimeTokens.Create(tok); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := b.OneTimeTokens.DeleteUser(taro.ID, TokenPurposeResetPassword); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.OneTimeTokens.Consume(reset.Hash, TokenPurposeResetPassword); !errors.Is(err, ErrTokenInvalid) {
+			t.Errorf("消したトークンが使えた: %v", err)
+		}
+		if _, err := b.OneTimeTokens.Consume(verify.Hash, TokenPurposeVerifyEmail); err != nil {
+			t.Errorf("別の用途のトークンまで消えた: %v", err)
+		}
+	})
+}
+
+// 新しい順に、上限（LoginHistoryLimit）まで残す
+func TestLoginHistoryRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b *Backend) {
+		taro := newRepoUser("taro", "")
+		if err := b.Users.Create(taro); err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+		for i := range LoginHistoryLimit + 5 {
+			event := LoginEvent{UserID: taro.ID, Time: start.Add(time.Duration(i) * time.Second), IP: "127.0.0.1"}
+			if err := b.LoginHistory.Record(event); err != nil {
+				t.Fatal(err)
+			}
+		}
+		events, err := b.LoginHistory.List(taro.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != LoginHistoryLimit {
+			t.Fatalf("%d 件, want %d", len(events), LoginHistoryLimit)
+		}
+		if want := start.Add(time.Duration(LoginHistoryLimit+4) * time.Second); !events[0].Time.Equal(want) {
+			t.Errorf("先頭 = %v, want 最新の %v", events[0].Time, want)
+		}
+		if err := b.LoginHistory.DeleteUser(taro.ID); err != nil {
+			t.Fatal(err)
+		}
+		if events, _ := b.LoginHistory.List(taro.ID); len(events) != 0 {
+			t.Errorf("DeleteUser の後に %d 件残っている", len(events))
+		}
+	})
+}
+
+// SQLite ではユーザーを消すと、履歴・ロール・セッション・使い捨てトークン・ログイン履歴が外部キーで一緒に消える
+func TestSQLiteDeleteCascades(t *testing.T) {
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	users := NewSQLiteUserRepository(db)
+	sessions := NewSQLiteSessionRepository(db)
+	tokens := NewSQLiteOneTimeTokenRepository(db)
+	history := NewSQLiteLoginHistoryRepository(db)
+
+	taro, hanako := newRepoUser("taro", ""), newRepoUser("hanako", "")
+	taro.PasswordHistory = [][]byte{[]byte("$2a$04$old")}
+	for _, u := range []*User{taro, hanako} {
+		if err := users.Create(u); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sessions.Create(u.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := tokens.Create(&OneTimeToken{Hash: hashToken(u.ID), Purpose: TokenPurposeResetPassword, UserID: u.ID, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := history.Record(LoginEvent{UserID: u.ID, Time: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := users.Delete(taro.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"password_history", "user_roles", "sessions", "one_time_tokens", "login_history"} {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		// hanako の分だけが残る（password_history は taro しか持っていない）
+		want := 1
+		if table == "password_history" {
+			want = 0
+		}
+		if n != want {
+			t.Errorf("%s: %d 行, want %d", table, n, want)
+		}
+	}
+
+	// いないユーザーには、セッションもトークンも作れない（外部キーで守られる）
+	if _, err := sessions.Create(taro.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("消したユーザーのセッション: err = %v, want ErrUserNotFound", err)
+	}
+}
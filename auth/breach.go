@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ===================
+// 漏洩パスワードのチェック
+// ===================
+
+// Have I Been Pwned（HIBP）と同じ、SHA-1 の先頭5文字（プレフィックス）で分けた索引を手元に持つ。
+// 照合するときはプレフィックスのファイル（バケット）を1つ読むだけでよい。
+//
+//	breach_index/
+//	  5B/
+//	    5BAA6.txt   ← SHA-1 が 5BAA6 で始まるものだけ
+//	                   1E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493
+//	                   └─ 残り35文字                      └─ 漏洩件数
+//
+// HIBP の API（k-匿名性）も同じ仕組みで、サーバーにはプレフィックスしか送らない。
+
+const breachPrefixLen = 5
+
+type BreachChecker struct {
+	dir       string
+	threshold int // この件数以上漏洩していたら使わせない
+}
+
+func NewBreachChecker(dir string, threshold int) *BreachChecker {
+	return &BreachChecker{dir: dir, threshold: max(1, threshold)}
+}
+
+func sha1Upper(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func bucketPath(dir, prefix string) string {
+	return filepath.Join(dir, prefix[:2], prefix+".txt")
+}
+
+// パスワードが漏洩データに何件含まれているかを返す（なければ 0）
+func (c *BreachChecker) Count(password string) (int, error) {
+	hash := sha1Upper(password)
+	prefix, suffix := hash[:breachPrefixLen], hash[breachPrefixLen:]
+
+	f, err := os.Open(bucketPath(c.dir, prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil // バケットがない = そのプレフィックスの漏洩はない
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		s, countStr, _ := strings.Cut(scanner.Text(), ":")
+		if s == suffix {
+			return strconv.Atoi(countStr)
+		}
+	}
+	return 0, scanner.Err()
+}
+
+// 閾値以上漏洩しているか
+func (c *BreachChecker) IsBreached(password string) (bool, int, error) {
+	count, err := c.Count(password)
+	if err != nil {
+		return false, 0, err
+	}
+	return count >= c.threshold, count, nil
+}
+
+// --- 索引の作成 ---
+
+// 一度にメモリに溜めておく件数。超えたらバケットファイルに書き出す
+const breachFlushSize = 1_000_000
+
+// 生のダンプ（1行に "SHA1:件数" または "SHA1" のみ）からプレフィックス索引を作る。
+// ダンプはソートされていなくてもよい（最後にバケットごとに並べ替えて件数をまとめる）
+func BuildBreachIndex(dumpPath, dir string) (int, error) {
+	// 既存の索引に追記すると件数が二重になるので、空のディレクトリにだけ作る
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return 0, fmt.Errorf("%s は空ではありません。削除してから作り直してください", dir)
+	}
+
+	in, err := os.Open(dumpPath)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	buckets := make(map[string][]string) // プレフィックス → "SUFFIX:件数" の行
+	buffered, total := 0, 0
+	touched := make(map[string]bool)
+
+	flush := func() error {
+		for prefix, lines := range buckets {
+			if err := appendBucket(dir, prefix, lines); err != nil {
+				return err
+			}
+			touched[prefix] = true
+		}
+		clear(buckets)
+		buffered = 0
+		return nil
+	}
+
+	scanner := bufio.NewScanner(in)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hash, countStr, hasCount := strings.Cut(line, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != sha1.Size*2 {
+			return total, fmt.Errorf("%d行目: SHA-1（40文字のhex）ではありません", lineNo)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return total, fmt.Errorf("%d行目: %w", lineNo, err)
+		}
+		count := 1
+		if hasCount {
+			if count, err = strconv.Atoi(strings.TrimSpace(countStr)); err != nil {
+				return total, fmt.Errorf("%d行目: 件数が数値ではありません", lineNo)
+			}
+		}
+
+		prefix := hash[:breachPrefixLen]
+		buckets[prefix] = append(buckets[prefix], fmt.Sprintf("%s:%d", hash[breachPrefixLen:], count))
+		buffered++
+		total++
+
+		if buffered >= breachFlushSize {
+			if err := flush(); err != nil {
+				return total, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return total, err
+	}
+	if err := flush(); err != nil {
+		return total, err
+	}
+
+	// 同じハッシュが複数回出てきた場合に件数を合計し、バケット内をソートする
+	for prefix := range touched {
+		if err := compactBucket(dir, prefix); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func appendBucket(dir, prefix string, lines []string) error {
+	path := bucketPath(dir, prefix)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, line := range lines {
+		w.WriteString(line + "\n")
+	}
+	return w.Flush()
+}
+
+func compactBucket(dir, prefix string) error {
+	path := bucketPath(dir, prefix)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[string]int)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		suffix, countStr, _ := strings.Cut(line, ":")
+		count, _ := strconv.Atoi(countStr)
+		counts[suffix] += count
+	}
+
+	suffixes := make([]string, 0, len(counts))
+	for suffix := range counts {
+		suffixes = append(suffixes, suffix)
+	}
+	sort.Strings(suffixes)
+
+	var b strings.Builder
+	for _, suffix := range suffixes {
+		fmt.Fprintf(&b, "%s:%d\n", suffix, counts[suffix])
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ダンプから索引を作り、漏洩したパスワードだけを違反にする
+func TestBreachIndexLookup(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "breach_index")
+	dump := filepath.Join(t.TempDir(), "dump.txt")
+	// 同じハッシュが2回出てきたら件数を合計する。小文字の hex も読める
+	lines := sha1Upper("hunter2-password") + ":3\n" +
+		strings.ToLower(sha1Upper("hunter2-password")) + ":4\n" +
+		sha1Upper("rarely-leaked-pass") + "\n"
+	if err := os.WriteFile(dump, []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := BuildBreachIndex(dump, dir); err != nil || n != 3 {
+		t.Fatalf("BuildBreachIndex = %d, %v", n, err)
+	}
+
+	checker := NewBreachChecker(dir, 2)
+	for _, tc := range []struct {
+		password string
+		count    int
+		breached bool
+	}{
+		{"hunter2-password", 7, true},
+		{"rarely-leaked-pass", 1, false}, // 閾値未満
+		{"never-leaked-pass", 0, false},
+	} {
+		breached, count, err := checker.IsBreached(tc.password)
+		if err != nil || count != tc.count || breached != tc.breached {
+			t.Errorf("%s: breached=%v count=%d err=%v, want %v %d", tc.password, breached, count, err, tc.breached, tc.count)
+		}
+	}
+
+	// 既存の索引に追記すると件数が二重になるので断る
+	if _, err := BuildBreachIndex(dump, dir); err == nil {
+		t.Error("空でないディレクトリに索引を作れた")
+	}
+}
+
+// 索引が読めないときはパスワードを通さず、登録は 503 になる
+func TestBreachCheckFailsClosed(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "breach_index")
+	if _, err := NewPasswordPolicy(PolicyConfig{BreachIndex: dir}); err == nil {
+		t.Fatal("索引ディレクトリがないのにポリシーを作れた")
+	}
+
+	// バケットのファイルのところにディレクトリがあって読めない
+	prefix := sha1Upper("quiet-lantern-orchard-42")[:breachPrefixLen]
+	if err := os.MkdirAll(bucketPath(dir, prefix), 0755); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := NewPasswordPolicy(PolicyConfig{MinLength: 8, BreachIndex: dir, BreachThreshold: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.Validate("taro", "quiet-lantern-orchard-42"); !errors.Is(err, ErrBreachCheckUnavailable) {
+		t.Fatalf("err = %v, want ErrBreachCheckUnavailable", err)
+	}
+
+	users, err := NewUserStore(NewMemoryUserRepository(), NewBcryptHasher(bcrypt.MinCost, false), policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &API{Users: users}
+	rec := postJSON(api.HandleRegister, "/register", AuthRequest{Username: "taro", Password: "quiet-lantern-orchard-42"})
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503: %s", rec.Code, rec.Body)
+	}
+	if _, err := users.Get("taro"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("確かめられなかったパスワードで登録された: %v", err)
+	}
+}
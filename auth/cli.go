@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// ===================
+// サーバー起動まわり
+// ===================
+
+// 各サーバー共通の管理用フラグとサブコマンド
+//
+//	go run . -rotate-pepper
+//	go run . migrate status
+type Commands struct {
+	ImportPath   string
+	RotatePepper bool
+	BreachDump   string
+	fs           *flag.FlagSet // フラグ以外の引数（サブコマンド）を読むため
+}
+
+func RegisterFlags(fs *flag.FlagSet) *Commands {
+	c := &Commands{fs: fs}
+	fs.StringVar(&c.ImportPath, "import", "", "旧システムのユーザーダンプ（.csv / .json）を起動時に取り込む")
+	fs.BoolVar(&c.RotatePepper, "rotate-pepper", false, "ペッパー鍵をローテーションして終了する")
+	fs.StringVar(&c.BreachDump, "build-breach-index", "", "漏洩パスワードのダンプ（SHA1:件数）から索引を作って終了する")
+	return c
+}
+
+// 起動せずに終わるコマンド（鍵のローテーション・索引の作成・マイグレーション）を実行する。
+// 実行したら true を返すので、呼び出し側はそのまま終了する
+func (c *Commands) Run(cfg Config) (bool, error) {
+	switch c.fs.Arg(0) {
+	case "":
+	case "migrate":
+		return true, runMigrate(cfg.Store, c.fs.Args()[1:])
+	default:
+		return true, fmt.Errorf("不明なサブコマンドです: %s（migrate）", c.fs.Arg(0))
+	}
+
+	if c.RotatePepper {
+		if cfg.Hash.PepperKeyring == "" {
+			return true, fmt.Errorf("config.json の hash.pepper_keyring に鍵ファイルのパスを指定してください")
+		}
+		key, err := RotatePepperKeyring(cfg.Hash.PepperKeyring)
+		if err != nil {
+			return true, err
+		}
+		fmt.Printf("ペッパー鍵を v=%d にローテーションしました（古い鍵のユーザーは次回ログイン時にかけ直されます）\n", key.Version)
+		return true, nil
+	}
+
+	if c.BreachDump != "" {
+		if cfg.Policy.BreachIndex == "" {
+			return true, fmt.Errorf("config.json の policy.breach_index に索引ディレクトリのパスを指定してください")
+		}
+		n, err := BuildBreachIndex(c.BreachDump, cfg.Policy.BreachIndex)
+		if err != nil {
+			return true, err
+		}
+		fmt.Printf("%d 件の漏洩ハッシュから索引を作りました: %s\n", n, cfg.Policy.BreachIndex)
+		return true, nil
+	}
+	return false, nil
+}
+
+// -import が指定されていれば旧システムのユーザーを取り込む
+func (c *Commands) ImportUsers(users *UserStore, cfg Config) {
+	if c.ImportPath == "" {
+		return
+	}
+	n, err := ImportLegacyUsers(users, c.ImportPath, cfg.Hash.BcryptCost)
+	if err != nil {
+		log.Printf("取り込めなかったユーザーがあります:\n%v", err)
+	}
+	log.Printf("旧システムから %d 人を取り込みました", n)
+}
+
+// 設定からハッシュ・ワーカープール・ポリシーを組み立てて UserStore を作る
+func NewUserStoreFromConfig(cfg Config, repo UserRepository) (*UserStore, *HashPool, error) {
+	hasher, err := NewHasherFromConfig(cfg.Hash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// bcrypt / Argon2id の同時実行数を制限する
+	pool := NewHashPool(cfg.Pool.Concurrency, time.Duration(cfg.Pool.QueueTimeoutMs)*time.Millisecond)
+	policy, err := NewPasswordPolicy(cfg.Policy)
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := NewUserStore(repo, NewPooledHasher(hasher, pool), policy)
+	if err != nil {
+		return nil, nil, err
+	}
+	store.verifyEmail = cfg.Email.Verify
+	if cfg.Account.AuditLog != "" {
+		audit, err := NewFileAuditLog(cfg.Account.AuditLog)
+		if err != nil {
+			return nil, nil, err
+		}
+		store.audit = audit
+	}
+	return store, pool, nil
+}
+
+// 退会したアカウントを猶予期間の後に消す削除ジョブを作る（Start で動き出す）
+func NewAccountPurgerFromConfig(cfg Config, users *UserStore, sessions SessionRepository, tokens OneTimeTokenRepository, history LoginHistoryRepository) *AccountPurger {
+	grace := time.Duration(cfg.Account.PurgeAfterDays) * 24 * time.Hour
+	interval := time.Duration(cfg.Account.PurgeIntervalMinutes) * time.Minute
+	return NewAccountPurger(users, sessions, tokens, history, grace, interval)
+}
+
+// 設定でメール確認が有効なら EmailVerifier を作る（無効なら nil）
+func NewEmailVerifierFromConfig(cfg Config, users *UserStore, tokens OneTimeTokenRepository) *EmailVerifier {
+	if !cfg.Email.Verify {
+		return nil
+	}
+	ttl := time.Duration(cfg.Email.VerifyTTLMinutes) * time.Minute
+	return NewEmailVerifier(users, tokens, NewMailerFromConfig(cfg.Email), cfg.Email.BaseURL, ttl)
+}
+
+// パスワードリセットを作る（メールアドレスを登録したユーザーだけが使える）
+func NewPasswordResetterFromConfig(cfg Config, users *UserStore, tokens OneTimeTokenRepository, sessions SessionRepository) *PasswordResetter {
+	ttl := time.Duration(cfg.Email.ResetTTLMinutes) * time.Minute
+	return NewPasswordResetter(users, tokens, sessions, NewMailerFromConfig(cfg.Email), cfg.Email.BaseURL, ttl)
+}
+
+// 登録・再送・リセットのメールを裏で送る順番待ちを作る
+func NewMailQueueFromConfig(cfg EmailConfig) *MailQueue {
+	return NewMailQueue(cfg.SendWorkers, cfg.SendQueueSize, time.Duration(cfg.ResendIntervalSeconds)*time.Second)
+}
+
+// smtp_addr があれば SMTP で送り、なければ outbox_dir にファイルで書き出す
+func NewMailerFromConfig(cfg EmailConfig) Mailer {
+	if cfg.SMTPAddr != "" {
+		return &SMTPMailer{Addr: cfg.SMTPAddr, From: cfg.From}
+	}
+	return NewFileOutbox(cfg.OutboxDir, cfg.From)
+}
+
+// --- migrate サブコマンド ---
+
+const migrateUsage = `使い方:
+  migrate status                       適用済み・未適用のマイグレーションを表示する
+  migrate up [-dry-run]                未適用のものを全て適用する
+  migrate down [-steps N] [-dry-run]   新しいものから N 個（デフォルト1）取り消す
+  migrate unlock                       止まったプロセスが残したロックを外す`
+
+func runMigrate(cfg StoreConfig, args []string) error {
+	if cfg.Backend != "sqlite" {
+		return fmt.Errorf("マイグレーションは store.backend が sqlite のときだけ使えます（現在: %s）", cfg.Backend)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("%s", migrateUsage)
+	}
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "実行せずに流す SQL を表示する")
+	steps := fs.Int("steps", 1, "取り消す数（down のみ）")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	opts := MigrateOptions{DryRun: *dryRun, Out: os.Stdout}
+
+	db, err := openSQLiteDB(cfg.SQLitePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "status":
+		return printMigrationStatus(db)
+	case "up":
+		n, err := MigrateUp(db, opts)
+		if err != nil {
+			return err
+		}
+		printMigrateResult(opts, "適用", n)
+	case "down":
+		n, err := MigrateDown(db, *steps, opts)
+		if err != nil {
+			return err
+		}
+		printMigrateResult(opts, "取り消", n)
+	case "unlock":
+		removed, err := UnlockMigrations(db)
+		if err != nil {
+			return err
+		}
+		if removed {
+			fmt.Println("ロックを外しました")
+		} else {
+			fmt.Println("ロックはかかっていません")
+		}
+	default:
+		return fmt.Errorf("%s", migrateUsage)
+	}
+	return nil
+}
+
+func printMigrateResult(opts MigrateOptions, verb string, n int) {
+	switch {
+	case opts.DryRun:
+		fmt.Printf("-- ドライラン: %d 個を%sします（DBは変更していません）\n", n, verb)
+	case n == 0:
+		fmt.Println("対象のマイグレーションはありません")
+	default:
+		fmt.Printf("%d 個を%sしました\n", n, verb)
+	}
+}
+
+func printMigrationStatus(db *sql.DB) error {
+	statuses, err := MigrationStatuses(db)
+	if err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		name := s.Name
+		if name == "" {
+			name = "（ファイルなし: 新しいバージョンで適用された？）"
+		}
+		state := "未適用"
+		if s.Applied {
+			state = "適用済み " + s.AppliedAt.Local().Format(time.DateTime)
+		}
+		fmt.Printf("%04d_%-30s %s\n", s.Version, name, state)
+	}
+
+	locked, err := MigrationLock(db)
+	if err != nil {
+		return err
+	}
+	if locked != nil {
+		fmt.Println("ロック中:", locked.Owner, locked.LockedAt.Local().Format(time.DateTime))
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ===================
+// 設定
+// ===================
+
+// 設定ファイルのパス（なければデフォルト値を使う）
+const ConfigPath = "config.json"
+
+type Config struct {
+	Hash    HashConfig    `json:"hash"`
+	Pool    PoolConfig    `json:"pool"`
+	Policy  PolicyConfig  `json:"policy"`
+	Store   StoreConfig   `json:"store"`
+	Email   EmailConfig   `json:"email"`
+	Lockout LockoutConfig `json:"lockout"`
+	Admin   AdminConfig   `json:"admin"`
+	Account AccountConfig `json:"account"`
+	Roles   Roles         `json:"roles"` // ロール → 権限（書いたロールだけデフォルトを置き換える）
+}
+
+// パスワードハッシュの設定
+type HashConfig struct {
+	Algorithm     string       `json:"algorithm"` // 新規登録で使う形式: "bcrypt" or "argon2id"
+	BcryptCost    int          `json:"bcrypt_cost"`
+	BcryptPreHash bool         `json:"bcrypt_prehash"` // SHA-384 で事前ハッシュしてから bcrypt にかける（72バイト制限対策）
+	Argon2        Argon2Params `json:"argon2"`
+	PepperKeyring string       `json:"pepper_keyring"` // ペッパー鍵ファイルのパス（空ならペッパーを使わない）
+}
+
+// ハッシュ計算のワーカープールの設定
+type PoolConfig struct {
+	Concurrency    int `json:"concurrency"`      // 同時に計算できる数
+	QueueTimeoutMs int `json:"queue_timeout_ms"` // 順番待ちの上限（超えたら 503）
+}
+
+// パスワードポリシーの設定
+type PolicyConfig struct {
+	MinLength       int     `json:"min_length"`
+	MaxLength       int     `json:"max_length"`
+	MinEntropyBits  float64 `json:"min_entropy_bits"`
+	CommonPasswords string  `json:"common_passwords"` // よく使われるパスワードのリスト（1行1つ。空なら組み込みのもの）
+	BreachIndex     string  `json:"breach_index"`     // 漏洩パスワードの索引ディレクトリ（空ならチェックしない）
+	BreachThreshold int     `json:"breach_threshold"` // この件数以上漏洩していたら拒否する
+	HistorySize     int     `json:"history_size"`     // 変更時に再利用を禁止する過去のパスワードの数
+	MinAgeMinutes   int     `json:"min_age_minutes"`  // 前回の変更から次に変更できるまでの分数（0なら制限なし）
+}
+
+// 保存先の設定
+type StoreConfig struct {
+	Backend    string `json:"backend"`     // "memory"（再起動で消える）or "sqlite"
+	SQLitePath string `json:"sqlite_path"` // SQLite のDBファイル
+}
+
+// メール（アドレスの確認・パスワードリセット）の設定
+type EmailConfig struct {
+	Verify           bool   `json:"verify"`             // 登録時にメールアドレスを必須にし、確認するまでログインさせない
+	BaseURL          string `json:"base_url"`           // メールに書くリンクの先頭
+	From             string `json:"from"`               // 送信元アドレス
+	SMTPAddr         string `json:"smtp_addr"`          // SMTPサーバー（host:port）。空なら outbox_dir に書き出す
+	OutboxDir        string `json:"outbox_dir"`         // 送るはずのメールを .eml で書き出すディレクトリ
+	VerifyTTLMinutes int    `json:"verify_ttl_minutes"` // 確認リンクの有効期限（分）
+	ResetTTLMinutes  int    `json:"reset_ttl_minutes"`  // パスワードリセットのリンクの有効期限（分）
+
+	// 登録・再送・リセットのメールを裏で送るときの上限（MailQueue）
+	SendWorkers           int `json:"send_workers"`            // 同時に送る数
+	SendQueueSize         int `json:"send_queue_size"`         // 送信待ちにできる数（超えたら捨てる）
+	ResendIntervalSeconds int `json:"resend_interval_seconds"` // 同じアドレスへ送る間隔（秒）
+}
+
+// ログイン試行の制限（アカウントごと）
+type LockoutConfig struct {
+	FreeAttempts      int `json:"free_attempts"`       // 待たずに試せる回数
+	BackoffBaseMs     int `json:"backoff_base_ms"`     // それを超えたときの最初の待ち時間（以後倍になる）
+	BackoffMaxSeconds int `json:"backoff_max_seconds"` // 待ち時間の上限
+	LockoutThreshold  int `json:"lockout_threshold"`   // この回数失敗したらロックする（0ならロックしない）
+	LockoutMinutes    int `json:"lockout_minutes"`     // ロックの長さ（過ぎれば自動で外れる）
+	ResetAfterMinutes int `json:"reset_after_minutes"` // 最後の失敗からこれだけ経てば数え直す
+}
+
+// 起動時に作る最初の管理者
+type AdminConfig struct {
+	Username    string `json:"username"`     // 空なら作らない
+	PasswordEnv string `json:"password_env"` // パスワードを読む環境変数（空ならランダムに作ってログに出す）
+}
+
+// アカウントの状態と削除の設定
+type AccountConfig struct {
+	PurgeAfterDays       int    `json:"purge_after_days"`       // 退会してから完全に消すまでの猶予期間（日）
+	PurgeIntervalMinutes int    `json:"purge_interval_minutes"` // 削除ジョブを回す間隔（分）
+	AuditLog             string `json:"audit_log"`              // 監査ログのファイル（空なら通常のログに出す）
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Hash: HashConfig{
+			Algorithm:     "argon2id",
+			BcryptCost:    bcrypt.DefaultCost,
+			BcryptPreHash: true,
+			Argon2:        DefaultArgon2Params,
+		},
+		Pool: PoolConfig{
+			Concurrency:    runtime.NumCPU(),
+			QueueTimeoutMs: 500,
+		},
+		Policy: PolicyConfig{
+			MinLength:       8,
+			MaxLength:       128,
+			MinEntropyBits:  30,
+			BreachThreshold: 1,
+			HistorySize:     5,
+		},
+		Store: StoreConfig{
+			Backend:    "memory",
+			SQLitePath: "auth.db",
+		},
+		Email: EmailConfig{
+			BaseURL:          "http://localhost:3000",
+			From:             "no-reply@localhost",
+			OutboxDir:        "outbox",
+			VerifyTTLMinutes: 24 * 60,
+			ResetTTLMinutes:  30,
+
+			SendWorkers:           2,
+			SendQueueSize:         100,
+			ResendIntervalSeconds: 60,
+		},
+		Lockout: LockoutConfig{
+			FreeAttempts:      3,
+			BackoffBaseMs:     1000,
+			BackoffMaxSeconds: 5 * 60,
+			LockoutThreshold:  10,
+			LockoutMinutes:    15,
+			ResetAfterMinutes: 60,
+		},
+		Admin: AdminConfig{
+			Username:    "admin",
+			PasswordEnv: "AUTH_ADMIN_PASSWORD",
+		},
+		Account: AccountConfig{
+			PurgeAfterDays:       30,
+			PurgeIntervalMinutes: 60,
+			AuditLog:             "audit.log",
+		},
+		Roles: DefaultRoles(),
+	}
+}
+
+// 設定ファイルを読み込む。書かれていない項目はデフォルト値のまま
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("設定ファイルのパースに失敗: %w", err)
+	}
+	if err := cfg.Hash.Argon2.validate(); err != nil {
+		return cfg, fmt.Errorf("hash.argon2: %w", err)
+	}
+	if err := cfg.Pool.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Account.validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// 同時実行数が0以下だとスロットが1つもなく、全てのログイン・登録が 503 になる
+func (c PoolConfig) validate() error {
+	if c.Concurrency < 1 {
+		return fmt.Errorf("pool.concurrency は1以上で指定してください: %d", c.Concurrency)
+	}
+	if c.QueueTimeoutMs < 0 {
+		return fmt.Errorf("pool.queue_timeout_ms は0以上で指定してください: %d", c.QueueTimeoutMs)
+	}
+	return nil
+}
+
+// 間隔が0以下だと削除ジョブの time.NewTicker が panic して起動できず、
+// 猶予期間が負だと退会したアカウントがすぐに全部消えるので、読み込んだときに断る
+func (c AccountConfig) validate() error {
+	if c.PurgeAfterDays < 0 {
+		return fmt.Errorf("account.purge_after_days は0以上で指定してください: %d", c.PurgeAfterDays)
+	}
+	if c.PurgeIntervalMinutes < 1 {
+		return fmt.Errorf("account.purge_interval_minutes は1以上で指定してください: %d", c.PurgeIntervalMinutes)
+	}
+	return nil
+}
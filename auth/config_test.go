@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// data を設定ファイルとして読み込み、読み込めるかどうかが ok と合っているか確かめる
+func checkLoadConfig(t *testing.T, data string, ok bool) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); (err == nil) != ok {
+		t.Errorf("%s: err = %v", data, err)
+	}
+}
+
+// 削除ジョブの間隔が0以下・猶予期間が負の設定は、読み込んだときにエラーにする
+func TestLoadConfigRejectsInvalidPurgeSettings(t *testing.T) {
+	for _, tc := range []struct {
+		json string
+		ok   bool
+	}{
+		{`{"account":{"purge_interval_minutes":0}}`, false},
+		{`{"account":{"purge_interval_minutes":-5}}`, false},
+		{`{"account":{"purge_after_days":-1}}`, false},
+		{`{"account":{"purge_after_days":0,"purge_interval_minutes":1}}`, true},
+		{`{}`, true},
+	} {
+		checkLoadConfig(t, tc.json, tc.ok)
+	}
+}
+
+// 検証できないハッシュを作ってしまう Argon2id のパラメータは、読み込んだときにエラーにする
+func TestLoadConfigRejectsInvalidArgon2Params(t *testing.T) {
+	for _, tc := range []struct {
+		json string
+		ok   bool
+	}{
+		{`{"hash":{"argon2":{"threads":0}}}`, false},
+		{`{"hash":{"argon2":{"time":0}}}`, false},
+		{`{"hash":{"argon2":{"memory":2097152}}}`, false},
+		{`{"hash":{"argon2":{"salt_len":0}}}`, false},
+		{`{"hash":{"argon2":{"memory":65536,"time":3,"threads":2}}}`, true},
+	} {
+		checkLoadConfig(t, tc.json, tc.ok)
+	}
+}
+
+// 同時実行数が0以下・待ち時間が負のプールは、読み込んだときにエラーにする
+func TestLoadConfigRejectsInvalidPoolSettings(t *testing.T) {
+	for _, tc := range []struct {
+		json string
+		ok   bool
+	}{
+		{`{"pool":{"concurrency":0}}`, false},
+		{`{"pool":{"concurrency":-1}}`, false},
+		{`{"pool":{"queue_timeout_ms":-1}}`, false},
+		{`{"pool":{"concurrency":1,"queue_timeout_ms":0}}`, true},
+	} {
+		checkLoadConfig(t, tc.json, tc.ok)
+	}
+}
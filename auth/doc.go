@@ -0,0 +1,9 @@
+// Package auth は、各フェーズのサーバーでコピーしていた認証の部品をまとめたライブラリ。
+//
+//   - UserStore: 登録・ログイン・パスワード変更（保存先は UserRepository）
+//   - SessionRepository: セッションの保存先（セッション方式）
+//   - TokenIssuer: トークンの発行と検証（JWT方式）
+//   - API: 上記を使った HTTP ハンドラー
+//
+// 保存先やトークンの形式はインターフェースなので、差し替えて使える。
+package auth
@@ -0,0 +1,12 @@
+module github.com/shin6142/go-login/auth
+
+go 1.25.7
+
+require (
+	github.com/mattn/go-sqlite3 v1.14.33
+	github.com/oklog/ulid/v2 v2.1.1
+	golang.org/x/crypto v0.48.0
+	golang.org/x/text v0.34.0
+)
+
+require golang.org/x/sys v0.41.0 // indirect
@@ -0,0 +1,330 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ===================
+// パスワードハッシュ
+// ===================
+
+// パスワードが一致しないときのエラー
+var ErrPasswordMismatch = errors.New("パスワードが一致しません")
+
+// 保存されたハッシュが壊れている・扱えないパラメータで作られているときのエラー
+var ErrInvalidHash = errors.New("ハッシュの形式が不正です")
+
+// PasswordHasher はパスワードのハッシュ化と検証を行う。
+// ハッシュは自己記述的な文字列（PHC形式）で保存するので、
+// 保存された値を見ればアルゴリズムとパラメータが分かる。
+type PasswordHasher interface {
+	// パスワードをハッシュ化する
+	Hash(password string) ([]byte, error)
+	// 保存されたハッシュとパスワードを比較する（不一致なら ErrPasswordMismatch）
+	Verify(hash []byte, password string) error
+	// このハッシュ形式を扱えるか
+	CanVerify(hash []byte) bool
+	// 現在の設定より弱いパラメータで作られたハッシュか（作り直すべきか）
+	NeedsRehash(hash []byte) bool
+}
+
+// --- bcrypt ---
+
+// bcrypt が扱えるのは先頭72バイトまで。
+// ライブラリによってはエラーになり、古い実装では73バイト目以降を黙って無視する。
+// 事前ハッシュモードでは SHA-384 → base64（64文字）にしてから bcrypt に渡すので、長さに関係なく全体が効く。
+//
+//	$2a$10$...                 ← そのまま bcrypt
+//	$bcrypt-sha384$2a$10$...   ← 事前ハッシュあり（目印で検証方法を切り替える）
+const bcryptPreHashPrefix = "$bcrypt-sha384"
+
+// bcrypt のハッシュは $2a$10$... の形式で、コストとソルトを含んでいる
+type BcryptHasher struct {
+	Cost    int
+	PreHash bool // true なら SHA-384 で事前ハッシュしてから bcrypt にかける
+}
+
+func NewBcryptHasher(cost int, preHash bool) *BcryptHasher {
+	return &BcryptHasher{Cost: cost, PreHash: preHash}
+}
+
+// SHA-384 の結果を base64 にする（48バイト → 64文字。生のバイト列だと途中の 0x00 で切れる実装があるため）
+func preHashPassword(password string) []byte {
+	sum := sha512.Sum384([]byte(password))
+	return []byte(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+func (h *BcryptHasher) Hash(password string) ([]byte, error) {
+	if !h.PreHash {
+		// 72バイトを超えると bcrypt.ErrPasswordTooLong になる
+		return bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	}
+	hash, err := bcrypt.GenerateFromPassword(preHashPassword(password), h.Cost)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(bcryptPreHashPrefix), hash...), nil
+}
+
+func (h *BcryptHasher) Verify(hash []byte, password string) error {
+	// 保存されたハッシュの目印で検証方法を決める（現在の設定ではない）
+	input := []byte(password)
+	if inner, ok := strings.CutPrefix(string(hash), bcryptPreHashPrefix); ok {
+		hash = []byte(inner)
+		input = preHashPassword(password)
+	}
+
+	err := bcrypt.CompareHashAndPassword(hash, input)
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+func (h *BcryptHasher) CanVerify(hash []byte) bool {
+	s := strings.TrimPrefix(string(hash), bcryptPreHashPrefix)
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
+
+// コストはハッシュ内に埋め込まれているので、設定値より低ければ作り直す。
+// 事前ハッシュの有無が設定と違う場合も作り直す
+func (h *BcryptHasher) NeedsRehash(hash []byte) bool {
+	if !h.CanVerify(hash) {
+		return true
+	}
+	inner, preHashed := strings.CutPrefix(string(hash), bcryptPreHashPrefix)
+	if preHashed != h.PreHash {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(inner))
+	if err != nil {
+		return true
+	}
+	return cost < h.Cost
+}
+
+// --- Argon2id ---
+
+// Argon2idのパラメータ
+type Argon2Params struct {
+	Memory  uint32 `json:"memory"`  // 使用メモリ（KiB）
+	Time    uint32 `json:"time"`    // 反復回数
+	Threads uint8  `json:"threads"` // 並列度
+	SaltLen uint32 `json:"salt_len"`
+	KeyLen  uint32 `json:"key_len"`
+}
+
+// OWASP推奨の最小構成（m=19MiB, t=2, p=1）
+var DefaultArgon2Params = Argon2Params{
+	Memory:  19 * 1024,
+	Time:    2,
+	Threads: 1,
+	SaltLen: 16,
+	KeyLen:  32,
+}
+
+// 検証で受け付けるパラメータの上限（calibrate が選ぶ範囲は m≦1GiB, t≦10）。
+// 保存されたハッシュのパラメータでそのまま計算するので、上限がないと1回のログインでいくらでもメモリを使える
+const (
+	maxArgon2Memory = 1024 * 1024 // KiB
+	maxArgon2Time   = 32
+)
+
+// RFC 9106 の下限（並列度・反復回数は1以上、ソルトは8バイト以上、ハッシュは4バイト以上）と上限を確かめる。
+// 並列度が0だと argon2.IDKey は panic する。設定ファイルの値にも同じ範囲を使う（範囲外で作ったハッシュは検証できない）
+func (p Argon2Params) validate() error {
+	switch {
+	case p.Threads < 1:
+		return fmt.Errorf("argon2idの並列度は1以上が必要です: p=%d", p.Threads)
+	case p.Time < 1 || p.Time > maxArgon2Time:
+		return fmt.Errorf("argon2idの反復回数は1〜%dで指定してください: t=%d", maxArgon2Time, p.Time)
+	case p.Memory < 8*uint32(p.Threads) || p.Memory > maxArgon2Memory:
+		return fmt.Errorf("argon2idのメモリは%d〜%dKiBで指定してください: m=%d", 8*uint32(p.Threads), maxArgon2Memory, p.Memory)
+	case p.SaltLen < 8:
+		return fmt.Errorf("argon2idのソルトは8バイト以上が必要です: %d", p.SaltLen)
+	case p.KeyLen < 4:
+		return fmt.Errorf("argon2idのハッシュは4バイト以上が必要です: %d", p.KeyLen)
+	}
+	return nil
+}
+
+// Argon2id のハッシュは PHC形式で保存する
+// 例: $argon2id$v=19$m=19456,t=2,p=1$<ソルト>$<ハッシュ>
+type Argon2idHasher struct {
+	Params Argon2Params
+}
+
+func NewArgon2idHasher(params Argon2Params) *Argon2idHasher {
+	return &Argon2idHasher{Params: params}
+}
+
+func (h *Argon2idHasher) Hash(password string) ([]byte, error) {
+	salt := make([]byte, h.Params.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Params.Time, h.Params.Memory, h.Params.Threads, h.Params.KeyLen)
+
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Params.Memory, h.Params.Time, h.Params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+	return []byte(encoded), nil
+}
+
+func (h *Argon2idHasher) Verify(hash []byte, password string) error {
+	// 検証は保存されたパラメータで行う（現在の設定ではない）
+	params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+
+	// 比較にかかる時間から情報が漏れないよう、定数時間で比較する
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func (h *Argon2idHasher) CanVerify(hash []byte) bool {
+	return strings.HasPrefix(string(hash), "$argon2id$")
+}
+
+// メモリ・反復回数・並列度・長さのどれかが設定値より小さければ作り直す
+func (h *Argon2idHasher) NeedsRehash(hash []byte) bool {
+	params, _, _, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params.Memory < h.Params.Memory ||
+		params.Time < h.Params.Time ||
+		params.Threads < h.Params.Threads ||
+		params.SaltLen < h.Params.SaltLen ||
+		params.KeyLen < h.Params.KeyLen
+}
+
+// PHC形式の文字列からパラメータ・ソルト・ハッシュを取り出す。
+// 壊れている・範囲外のパラメータなら ErrInvalidHash（計算する前に断る）
+func parseArgon2id(hash []byte) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	// "" / "argon2id" / "v=19" / "m=...,t=...,p=..." / ソルト / ハッシュ
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, fmt.Errorf("%w: argon2idの区切りが足りません", ErrInvalidHash)
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, fmt.Errorf("%w: argon2idのバージョンが読めません: %v", ErrInvalidHash, err)
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("%w: 未対応のargon2バージョンです: %d", ErrInvalidHash, version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, fmt.Errorf("%w: argon2idのパラメータが読めません: %v", ErrInvalidHash, err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("%w: ソルトのデコードに失敗: %v", ErrInvalidHash, err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("%w: ハッシュのデコードに失敗: %v", ErrInvalidHash, err)
+	}
+	params.SaltLen = uint32(len(salt))
+	params.KeyLen = uint32(len(key))
+	if err := params.validate(); err != nil {
+		return params, nil, nil, fmt.Errorf("%w: %v", ErrInvalidHash, err)
+	}
+
+	return params, salt, key, nil
+}
+
+// --- 複数形式の併用 ---
+
+// MultiHasher は新規ハッシュを primary で作り、
+// 検証は保存された形式に合う Hasher に任せる。
+// これで既存ユーザーのbcryptハッシュを壊さずに、新規ユーザーをArgon2idへ移行できる。
+type MultiHasher struct {
+	primary PasswordHasher
+	hashers []PasswordHasher // primary を含む、検証できる全ての形式
+}
+
+func NewMultiHasher(primary PasswordHasher, others ...PasswordHasher) *MultiHasher {
+	return &MultiHasher{
+		primary: primary,
+		hashers: append([]PasswordHasher{primary}, others...),
+	}
+}
+
+func (m *MultiHasher) Hash(password string) ([]byte, error) {
+	return m.primary.Hash(password)
+}
+
+func (m *MultiHasher) Verify(hash []byte, password string) error {
+	for _, h := range m.hashers {
+		if h.CanVerify(hash) {
+			return h.Verify(hash, password)
+		}
+	}
+	return fmt.Errorf("未対応のハッシュ形式です")
+}
+
+func (m *MultiHasher) CanVerify(hash []byte) bool {
+	for _, h := range m.hashers {
+		if h.CanVerify(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// primary 以外の形式（古いアルゴリズム）なら常に作り直す
+func (m *MultiHasher) NeedsRehash(hash []byte) bool {
+	if !m.primary.CanVerify(hash) {
+		return true
+	}
+	return m.primary.NeedsRehash(hash)
+}
+
+// 設定から PasswordHasher を組み立てる
+func NewHasherFromConfig(cfg HashConfig) (PasswordHasher, error) {
+	bcryptHasher := NewBcryptHasher(cfg.BcryptCost, cfg.BcryptPreHash)
+	argon2Hasher := NewArgon2idHasher(cfg.Argon2)
+	legacyHasher := NewLegacyOnionHasher() // 旧システムから取り込んだハッシュの検証用
+
+	var base *MultiHasher
+	switch cfg.Algorithm {
+	case "bcrypt":
+		base = NewMultiHasher(bcryptHasher, argon2Hasher, legacyHasher)
+	case "argon2id":
+		base = NewMultiHasher(argon2Hasher, bcryptHasher, legacyHasher)
+	default:
+		return nil, fmt.Errorf("未対応のハッシュアルゴリズムです: %s", cfg.Algorithm)
+	}
+
+	if cfg.PepperKeyring == "" {
+		return base, nil
+	}
+
+	// ペッパーを使う場合は、ペッパーなしのハッシュも検証だけはできるようにしておく
+	// （次回ログイン時にペッパー付きで作り直される）
+	ring, err := LoadPepperKeyring(cfg.PepperKeyring)
+	if err != nil {
+		return nil, fmt.Errorf("ペッパー鍵の読み込みに失敗: %w", err)
+	}
+	return NewMultiHasher(NewPepperedHasher(base, ring), base), nil
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 72バイトを超えたところだけが違うパスワードは、事前ハッシュがあれば別物として扱われる
+func TestBcryptPreHashDistinguishesPasswordsAfter72Bytes(t *testing.T) {
+	h := NewBcryptHasher(bcrypt.MinCost, true)
+	prefix := strings.Repeat("a", 72)
+	a, b := prefix+"-first", prefix+"-second"
+
+	hash, err := h.Hash(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Verify(hash, a); err != nil {
+		t.Fatalf("同じパスワードが一致しない: %v", err)
+	}
+	if err := h.Verify(hash, b); !errors.Is(err, ErrPasswordMismatch) {
+		t.Fatalf("73バイト目以降が違うパスワードで err = %v, want ErrPasswordMismatch", err)
+	}
+}
+
+// 事前ハッシュの目印はハッシュに残り、設定を変えても目印で検証方法が決まる
+func TestBcryptPreHashMarkerRoundTrip(t *testing.T) {
+	pre := NewBcryptHasher(bcrypt.MinCost, true)
+	plain := NewBcryptHasher(bcrypt.MinCost, false)
+	password := strings.Repeat("x", 100)
+
+	hash, err := pre.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(hash), bcryptPreHashPrefix+"$2a$") {
+		t.Fatalf("目印がない: %s", hash)
+	}
+	if !pre.CanVerify(hash) || !plain.CanVerify(hash) {
+		t.Fatal("目印つきのハッシュを扱えない")
+	}
+	// 事前ハッシュをやめた設定でも、保存されたハッシュの目印で検証できる
+	if err := plain.Verify(hash, password); err != nil {
+		t.Fatalf("事前ハッシュなしの設定で検証できない: %v", err)
+	}
+	if pre.NeedsRehash(hash) {
+		t.Error("同じ設定なのに作り直しになる")
+	}
+	if !plain.NeedsRehash(hash) {
+		t.Error("事前ハッシュの有無が設定と違うのに作り直しにならない")
+	}
+}
+
+// 事前ハッシュを始める前の素の bcrypt のハッシュも、そのまま検証できる（次のログインで作り直す）
+func TestBcryptPlainHashStillVerifies(t *testing.T) {
+	pre := NewBcryptHasher(bcrypt.MinCost, true)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pre.Verify(hash, "secret-password"); err != nil {
+		t.Fatalf("素の bcrypt のハッシュが検証できない: %v", err)
+	}
+	if err := pre.Verify(hash, "other-password"); !errors.Is(err, ErrPasswordMismatch) {
+		t.Fatalf("違うパスワードで err = %v, want ErrPasswordMismatch", err)
+	}
+	if !pre.NeedsRehash(hash) {
+		t.Error("素の bcrypt のハッシュが作り直しにならない")
+	}
+}
+
+// 壊れた・範囲外のパラメータの PHC 文字列は、計算する前に ErrInvalidHash で断る（p=0 で panic しない）
+func TestArgon2idRejectsMalformedHashes(t *testing.T) {
+	h := NewArgon2idHasher(Argon2Params{Memory: 64, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32})
+	valid, err := h.Hash("secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(string(valid), "$")
+	salt, key := parts[4], parts[5]
+	if err := h.Verify(valid, "secret-password"); err != nil {
+		t.Fatalf("正しいハッシュが検証できない: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		hash string
+	}{
+		{"区切りが足りない", "$argon2id$v=19$m=64,t=1,p=1$" + salt},
+		{"バージョン違い", "$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key},
+		{"パラメータが読めない", "$argon2id$v=19$m=64,t=1$" + salt + "$" + key},
+		{"並列度0", "$argon2id$v=19$m=64,t=1,p=0$" + salt + "$" + key},
+		{"反復回数0", "$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + key},
+		{"反復回数が上限超え", "$argon2id$v=19$m=64,t=1000000,p=1$" + salt + "$" + key},
+		{"メモリが上限超え", "$argon2id$v=19$m=4294967295,t=1,p=1$" + salt + "$" + key},
+		{"ソルトが短い", "$argon2id$v=19$m=64,t=1,p=1$YWJj$" + key},
+		{"ハッシュが空", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$"},
+		{"base64でない", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$!!!"},
+	} {
+		err := h.Verify([]byte(tc.hash), "secret-password")
+		if !errors.Is(err, ErrInvalidHash) {
+			t.Errorf("%s: err = %v, want ErrInvalidHash", tc.name, err)
+		}
+		if !h.NeedsRehash([]byte(tc.hash)) {
+			t.Errorf("%s: 作り直しにならない", tc.name)
+		}
+	}
+}
+
+// テスト用の軽い Argon2id（数ミリ秒で終わる）
+var testArgon2Params = Argon2Params{Memory: 64, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32}
+
+// 新規は primary で作り、検証は保存された形式に合わせる。primary 以外の形式・弱いパラメータは作り直す
+func TestMultiHasherVerifiesAndRehashes(t *testing.T) {
+	weakBcrypt := NewBcryptHasher(bcrypt.MinCost, false)
+	weakArgon2 := NewArgon2idHasher(testArgon2Params)
+	stronger := testArgon2Params
+	stronger.Time = 2
+	multi := NewMultiHasher(NewArgon2idHasher(stronger), weakBcrypt)
+
+	bcryptHash, err := weakBcrypt.Hash("secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	weakHash, err := weakArgon2.Hash("secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentHash, err := multi.Hash("secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(currentHash), "$argon2id$") {
+		t.Fatalf("primary の形式で作られていない: %s", currentHash)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		hash   []byte
+		rehash bool
+	}{
+		{"primary 以外の形式", bcryptHash, true},
+		{"primary より弱いパラメータ", weakHash, true},
+		{"現在の設定", currentHash, false},
+	} {
+		if err := multi.Verify(tc.hash, "secret-password"); err != nil {
+			t.Errorf("%s: 検証できない: %v", tc.name, err)
+		}
+		if err := multi.Verify(tc.hash, "wrong-password"); !errors.Is(err, ErrPasswordMismatch) {
+			t.Errorf("%s: 違うパスワードで err = %v, want ErrPasswordMismatch", tc.name, err)
+		}
+		if got := multi.NeedsRehash(tc.hash); got != tc.rehash {
+			t.Errorf("%s: NeedsRehash = %v, want %v", tc.name, got, tc.rehash)
+		}
+	}
+
+	// どの形式にも合わないハッシュは、一致しないのではなくエラーにする
+	unknown := []byte("$scrypt$ln=15,r=8,p=1$c2FsdA$aGFzaA")
+	if multi.CanVerify(unknown) {
+		t.Error("未対応の形式を扱えることになっている")
+	}
+	if err := multi.Verify(unknown, "secret-password"); err == nil || errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("未対応の形式で err = %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ===================
+// ログイン履歴
+// ===================
+
+// ログインに成功するたびに、日時・接続元・ブラウザを残す（個人データのエクスポートで本人に見せる）。
+// ユーザーごとに新しいものから LoginHistoryLimit 件だけ持ち、古いものは消える
+
+const LoginHistoryLimit = 50
+
+type LoginEvent struct {
+	UserID    string    `json:"-"`
+	Time      time.Time `json:"time"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+}
+
+// LoginHistoryRepository はログイン履歴の保存先
+type LoginHistoryRepository interface {
+	Record(event LoginEvent) error
+	List(userID string) ([]LoginEvent, error) // 新しい順
+	DeleteUser(userID string) error
+}
+
+// リクエストからログインの記録を作る（プロキシの後ろでも X-Forwarded-For は信じない。偽装できるため）
+func newLoginEvent(r *http.Request, user *User) LoginEvent {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return LoginEvent{UserID: user.ID, Time: time.Now(), IP: ip, UserAgent: r.UserAgent()}
+}
+
+// --- インメモリの保存先 ---
+
+// 1人あたりの件数に上限があるので、シャードに分けずに1つのロックで守る
+type MemoryLoginHistoryRepository struct {
+	mu     sync.Mutex
+	events map[string][]LoginEvent // key: ユーザーID。古い順
+}
+
+func NewMemoryLoginHistoryRepository() *MemoryLoginHistoryRepository {
+	return &MemoryLoginHistoryRepository{events: make(map[string][]LoginEvent)}
+}
+
+func (r *MemoryLoginHistoryRepository) Record(event LoginEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := append(r.events[event.UserID], event)
+	if len(events) > LoginHistoryLimit {
+		events = events[len(events)-LoginHistoryLimit:]
+	}
+	r.events[event.UserID] = events
+	return nil
+}
+
+func (r *MemoryLoginHistoryRepository) List(userID string) ([]LoginEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events[userID]
+	list := make([]LoginEvent, len(events))
+	for i, e := range events {
+		list[len(events)-1-i] = e
+	}
+	return list, nil
+}
+
+func (r *MemoryLoginHistoryRepository) DeleteUser(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.events, userID)
+	return nil
+}
@@ -0,0 +1,709 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ===================
+// HTTPハンドラー
+// ===================
+
+// API は UserStore に HTTP の口をつけたもの。
+// Sessions を設定すればセッション方式（Cookie）、Tokens を設定すれば JWT方式（Bearer）になる。
+// どちらも nil なら、ログイン状態を持たないサーバーになる
+type API struct {
+	Users    *UserStore
+	Sessions SessionRepository      // nilならセッションを使わない
+	Tokens   TokenIssuer            // nilならトークンを発行しない
+	Verifier *EmailVerifier         // nilならメールアドレスを確認しない
+	Resetter *PasswordResetter      // nilならパスワードリセットを使わない
+	Throttle *LoginThrottle         // nilならログインの試行回数を制限しない
+	History  LoginHistoryRepository // nilならログイン履歴を残さない
+	Roles    Roles                  // ロールごとの権限（nilなら DefaultRoles）
+	Mail     *MailQueue             // 登録・再送・リセットのメールの送信待ち（nilなら DefaultConfig の上限で作る）
+
+	mailOnce sync.Once
+}
+
+const sessionCookieName = "session_id"
+
+// リクエストボディの構造体
+type AuthRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email,omitempty"` // 登録時のみ（メール確認が有効なら必須）
+}
+
+// メール確認・再送・パスワードリセットのリクエスト
+type EmailRequest struct {
+	Email string `json:"email,omitempty"`
+	Token string `json:"token,omitempty"`
+}
+
+// パスワードリセット（リンクのトークンと新しいパスワード）
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// パスワード変更リクエスト
+// ログイン状態を持たないサーバーでは、ユーザー名もボディで送る
+type ChangePasswordRequest struct {
+	Username        string `json:"username,omitempty"`
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// レスポンスの構造体
+type Response struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+type LoginResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Token   string `json:"token,omitempty"` // JWT方式のときだけ返す
+}
+
+// プロフィール（ロールと、それで使える権限も返す）
+type ProfileResponse struct {
+	Success     bool         `json:"success"`
+	Message     string       `json:"message"`
+	ID          string       `json:"id"`
+	Username    string       `json:"username"`
+	Roles       []string     `json:"roles"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// 登録・変更失敗時のレスポンス（ポリシー違反の詳細を含む）
+type RegisterErrorResponse struct {
+	Success bool              `json:"success"`
+	Message string            `json:"message"`
+	Errors  []PolicyViolation `json:"errors"`
+}
+
+// JSONレスポンスを返すヘルパー関数
+func JSONResponse(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// ハッシュ計算が混んでいる・漏洩パスワードの索引が読めないときは 503 を返す（混んでいるときは Retry-After も）
+func overloadedResponse(w http.ResponseWriter, err error) bool {
+	if errors.Is(err, ErrBreachCheckUnavailable) {
+		log.Printf("漏洩パスワードの照合に失敗: %v", err)
+		JSONResponse(w, http.StatusServiceUnavailable, Response{false, ErrBreachCheckUnavailable.Error()})
+		return true
+	}
+	var busy *HashPoolBusyError
+	if !errors.As(err, &busy) {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(busy.RetryAfterSeconds()))
+	JSONResponse(w, http.StatusServiceUnavailable, Response{false, "混み合っています。しばらくしてから再試行してください"})
+	return true
+}
+
+// アカウントごとの試行回数の制限のキー（Taro と taro を別々に数えないよう、正規化した名前で数える）
+func throttleKey(username string) string {
+	key, err := CanonicalUsername(username)
+	if err != nil {
+		return username
+	}
+	return key
+}
+
+// パスワードを検証する前に、試行を数える（ログイン・パスワード変更・アカウント削除で同じキーを共有する。
+// どこか1つでも数えないと、そこからパスワードを何度でも試せてしまう）。制限中なら 429 を返して false
+func (a *API) beginAttempt(w http.ResponseWriter, key string) bool {
+	if a.Throttle == nil {
+		return true
+	}
+	if err := a.Throttle.Attempt(key); err != nil {
+		throttledResponse(w, err)
+		return false
+	}
+	return true
+}
+
+// 検証の結果に合わせて、先に数えた試行を片付ける。
+// パスワードが違った（wrong）なら失敗のまま残し、混雑などで検証できなかったら取り消し、合っていたら数え直す
+func (a *API) finishAttempt(key string, err, wrong error) {
+	if a.Throttle == nil {
+		return
+	}
+	_, violated := PolicyViolations(err)
+	switch {
+	case errors.Is(err, wrong):
+	case err == nil || violated || errors.Is(err, ErrPasswordChanged) || errors.Is(err, ErrLastAdmin):
+		a.Throttle.Succeeded(key)
+	default:
+		a.Throttle.Cancel(key)
+	}
+}
+
+// 試行回数の制限に引っかかったときは 429 + Retry-After を返す
+func throttledResponse(w http.ResponseWriter, err error) bool {
+	var throttled *LoginThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(throttled.RetryAfterSeconds()))
+	JSONResponse(w, http.StatusTooManyRequests, Response{false, err.Error()})
+	return true
+}
+
+// Authorizationヘッダーからトークンを取得
+func extractToken(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", fmt.Errorf("Authorizationヘッダーがありません")
+	}
+
+	// "Bearer <token>" の形式をパース
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return "", fmt.Errorf("無効なAuthorizationヘッダー形式")
+	}
+
+	return parts[1], nil
+}
+
+// リンクから開くページのフォームと curl の両方から受け取るため、JSON でもフォーム（token=...）でも読む。
+// Content-Type は当てにならない（curl -d は JSON でもフォームとして送る）ので、中身で判断する
+func decodeJSONOrForm(r *http.Request, dst any) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		return err
+	}
+	if json.Unmarshal(body, dst) == nil {
+		return nil
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return err
+	}
+	// フォームの項目名を JSON のキーとして読み直す
+	fields := make(map[string]string)
+	for key := range form {
+		fields[key] = form.Get(key)
+	}
+	data, _ := json.Marshal(fields)
+	return json.Unmarshal(data, dst)
+}
+
+// --- CSRF 対策 ---
+
+// Cookie で認証して状態を変えるリクエスト（管理者の操作・アカウントの削除）は、JSON でしか受け付けない。
+// 別のサイトのフォームは application/json を送れず、fetch で送るには CORS の事前確認が要るので、
+// セッション Cookie の SameSite=Lax と合わせて、別のサイトから操作させられるのを防ぐ。
+// 受け付けないときは 415 を返して false
+func requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && mediaType == "application/json" {
+		return true
+	}
+	JSONResponse(w, http.StatusUnsupportedMediaType, Response{false, "Content-Type: application/json で送ってください"})
+	return false
+}
+
+// JSON のボディを読む。項目がすべて任意のリクエストのため、空のボディはエラーにしない
+func decodeJSON(r *http.Request, dst any) error {
+	err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(dst)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// ログイン中のユーザーを返す（セッションCookie → Bearerトークン の順に見る）。
+// ロールは保存先から読み直すので、変更はすぐに効く（JWT に入れると期限まで古いロールのまま）
+func (a *API) CurrentUser(r *http.Request) (*User, error) {
+	if user := UserFromContext(r.Context()); user != nil {
+		return user, nil // Require で確かめ済み
+	}
+	if a.Sessions != nil {
+		if cookie, err := r.Cookie(sessionCookieName); err == nil {
+			session, err := a.Sessions.Get(cookie.Value)
+			if err != nil {
+				return nil, err
+			}
+			user, err := a.Users.GetByID(session.UserID)
+			if err != nil {
+				return nil, err
+			}
+			return a.checkAccountStatus(user)
+		}
+	}
+	if a.Tokens != nil {
+		token, err := extractToken(r)
+		if err != nil {
+			return nil, err
+		}
+		payload, err := a.Tokens.Verify(token)
+		if err != nil {
+			return nil, err
+		}
+		// JWTはサーバーで消せないので、パスワードを変更・リセットする前に発行されたものはパスワードの番号で弾く
+		user, err := a.Users.GetByID(payload.Subject)
+		if err != nil {
+			return nil, err
+		}
+		if payload.PasswordVersion != user.PasswordVersion {
+			return nil, fmt.Errorf("パスワードが変更されたため、このトークンは使えません。ログインし直してください")
+		}
+		return a.checkAccountStatus(user)
+	}
+	return nil, fmt.Errorf("ログインしてください")
+}
+
+// 停止・退会した・リセットを求められたユーザーは、ログイン中でも弾く
+func (a *API) checkAccountStatus(user *User) (*User, error) {
+	if err := a.Users.checkState(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ユーザー登録
+func (a *API) HandleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JSONResponse(w, http.StatusMethodNotAllowed, Response{false, "POSTメソッドを使用してください"})
+		return
+	}
+
+	var req AuthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		JSONResponse(w, http.StatusBadRequest, Response{false, "無効なJSONです"})
+		return
+	}
+
+	if req.Username == "" || req.Password == "" {
+		JSONResponse(w, http.StatusBadRequest, Response{false, "ユーザー名とパスワードは必須です"})
+		return
+	}
+	if a.Verifier != nil && req.Email == "" {
+		JSONResponse(w, http.StatusBadRequest, Response{false, "メールアドレスは必須です"})
+		return
+	}
+
+	err := a.Users.Register(req.Username, req.Email, req.Password)
+	duplicate := errors.Is(err, ErrUserExists) || errors.Is(err, ErrEmailExists)
+	if err != nil && !(duplicate && a.Verifier != nil) {
+		if overloadedResponse(w, err) {
+			return
+		}
+		if violations, ok := PolicyViolations(err); ok {
+			JSONResponse(w, http.StatusBadRequest, RegisterErrorResponse{false, err.Error(), violations})
+			return
+		}
+		if errors.Is(err, ErrInvalidUsername) || errors.Is(err, ErrInvalidEmail) {
+			JSONResponse(w, http.StatusBadRequest, Response{false, err.Error()})
+			return
+		}
+		if duplicate {
+			// メールで知らせる手段がないので、使えないことは返すしかない（名前とアドレスのどちらかは言わない）
+			JSONResponse(w, http.StatusConflict, Response{false, "このユーザー名またはメールアドレスは使えません"})
+			return
+		}
+		log.Printf("登録に失敗: %s: %v", req.Username, err)
+		JSONResponse(w, http.StatusInternalServerError, Response{false, "登録に失敗しました"})
+		return
+	}
+
+	if a.Verifier == nil {
+		log.Printf("ユーザー登録: %s", req.Username)
+		JSONResponse(w, http.StatusCreated, Response{true, fmt.Sprintf("ユーザー '%s' を登録しました", req.Username)})
+		return
+	}
+
+	// メール確認が有効なら、使われている名前・アドレスでも新規登録と同じ返事をする。
+	// アドレスが登録済みなら、そのアドレスへのメールで持ち主にだけ知らせる。
+	// 名前が使われていたことはメールでも知らせない（入力したアドレスに届くので、自分の受信箱で名前を調べられてしまう）。
+	// 名前が使われていると確認メールが届かないので、登録した人は別の名前で登録し直す。
+	// メールを送るかどうかで応答時間が変わらないよう、送信は再送と同じく裏で行う（同じアドレスへの間隔も同じ）
+	switch {
+	case errors.Is(err, ErrEmailExists):
+		a.enqueueRegisterMail(req.Email, func() error { return a.Verifier.SendDuplicateNotice(req.Email) })
+	case duplicate:
+		log.Printf("使われているユーザー名で登録: %s", req.Username)
+	default:
+		log.Printf("ユーザー登録: %s", req.Username)
+		if user, err := a.Users.Get(req.Username); err != nil {
+			log.Printf("確認メールを送れません: %s: %v", req.Username, err)
+		} else {
+			a.enqueueRegisterMail(req.Email, func() error { return a.Verifier.SendVerification(user) })
+		}
+	}
+	JSONResponse(w, http.StatusCreated, Response{true,
+		"登録を受け付けました。確認メールのリンクを開いてからログインしてください（届かない場合は /email/resend で送り直せます）"})
+}
+
+// ログイン（セッション方式なら Cookie を、JWT方式ならトークンを返す）
+func (a *API) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JSONResponse(w, http.StatusMethodNotAllowed, Response{false, "POSTメソッドを使用してください"})
+		return
+	}
+
+	var req AuthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		JSONResponse(w, http.StatusBadRequest, Response{false, "無効なJSONです"})
+		return
+	}
+
+	key := throttleKey(req.Username)
+	if !a.beginAttempt(w, key) {
+		return
+	}
+
+	// パスワード認証
+	user, err := a.Users.Authenticate(req.Username, req.Password)
+	if err != nil {
+		if overloadedResponse(w, err) {
+			if a.Throttle != nil {
+				a.Throttle.Cancel(key) // 混雑で検証できなかった分は失敗に数えない
+			}
+			return
+		}
+		if errors.Is(err, ErrAccountUnavailable) || errors.Is(err, ErrPasswordResetRequired) {
+			// パスワードは合っているので、試行の制限は数え直す
+			if a.Throttle != nil {
+				a.Throttle.Succeeded(key)
+			}
+			// 本当の理由はサーバーのログにだけ出し、返事はどの状態でも同じにする
+			var stateErr *AccountStateError
+			if errors.As(err, &stateErr) {
+				log.Printf("ログインを拒否: %s (%s: %s)", key, stateErr.State, stateErr.Reason)
+			}
+			JSONResponse(w, http.StatusForbidden, Response{false, err.Error()})
+			return
+		}
+		JSONResponse(w, http.StatusUnauthorized, Response{false, err.Error()})
+		return
+	}
+	if a.Throttle != nil {
+		a.Throttle.Succeeded(key)
+	}
+
+	// セッション・トークンには変わらない ID を入れ、表示には登録時の名前を使う
+	resp := LoginResponse{Success: true, Message: fmt.Sprintf("ようこそ、%s さん！", user.DisplayName)}
+
+	if a.Sessions != nil {
+		session, err := a.Sessions.Create(user.ID)
+		if err != nil {
+			JSONResponse(w, http.StatusInternalServerError, Response{false, "セッション作成に失敗"})
+			return
+		}
+		// CookieにセッションIDを設定
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookieName,
+			Value:    session.ID,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode, // 別のサイトからの POST には Cookie をつけない（CSRF 対策）
+			Expires:  session.ExpiresAt,
+		})
+	}
+
+	if a.Tokens != nil {
+		// JWT生成（サーバーには保存しない）
+		if resp.Token, err = a.Tokens.Issue(user.ID, user.PasswordVersion); err != nil {
+			JSONResponse(w, http.StatusInternalServerError, Response{false, "トークン生成に失敗"})
+			return
+		}
+	}
+
+	if a.History != nil {
+		if err := a.History.Record(newLoginEvent(r, user)); err != nil {
+			log.Printf("ログイン履歴の保存に失敗: %s: %v", user.Username, err)
+		}
+	}
+
+	log.Printf("ログイン成功: %s", user.Username)
+	JSONResponse(w, http.StatusOK, resp)
+}
+
+// プロフィール（認証が必要）
+func (a *API) HandleProfile(w http.ResponseWriter, r *http.Request) {
+	user, err := a.CurrentUser(r)
+	if err != nil {
+		JSONResponse(w, http.StatusUnauthorized, Response{false, err.Error()})
+		return
+	}
+
+	JSONResponse(w, http.StatusOK, ProfileResponse{
+		Success:     true,
+		Message:     fmt.Sprintf("こんにちは、%s さん！", user.DisplayName),
+		ID:          user.ID,
+		Username:    user.Username,
+		Roles:       user.Roles,
+		Permissions: a.roles().Permissions(user),
+	})
+}
+
+// パスワード変更（ログイン状態を持つサーバーでは認証が必要）
+func (a *API) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JSONResponse(w, http.StatusMethodNotAllowed, Response{false, "POSTメソッドを使用してください"})
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		JSONResponse(w, http.StatusBadRequest, Response{false, "無効なJSONです"})
+		return
+	}
+
+	// ログイン中のユーザーを特定（ボディのユーザー名は使わない）
+	username := req.Username
+	if a.Sessions != nil || a.Tokens != nil {
+		user, err := a.CurrentUser(r)
+		if err != nil {
+			JSONResponse(w, http.StatusUnauthorized, Response{false, err.Error()})
+			return
+		}
+		username = user.Username
+	}
+
+	// 現在のパスワードを試せる回数は、ログインと合わせて数える
+	key := throttleKey(username)
+	if !a.beginAttempt(w, key) {
+		return
+	}
+	err := a.Users.ChangePassword(username, req.CurrentPassword, req.NewPassword)
+	a.finishAttempt(key, err, ErrWrongCurrentPassword)
+	if err != nil {
+		if overloadedResponse(w, err) {
+			return
+		}
+		if violations, ok := PolicyViolations(err); ok {
+			JSONResponse(w, http.StatusBadRequest, RegisterErrorResponse{false, err.Error(), violations})
+			return
+		}
+		if errors.Is(err, ErrPasswordChanged) {
+			JSONResponse(w, http.StatusConflict, Response{false, err.Error()})
+			return
+		}
+		JSONResponse(w, http.StatusUnauthorized, Response{false, err.Error()})
+		return
+	}
+
+	JSONResponse(w, http.StatusOK, Response{true, "パスワードを変更しました"})
+}
+
+// ログアウト（セッション方式のみ。JWTはクライアントがトークンを捨てる）
+func (a *API) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JSONResponse(w, http.StatusMethodNotAllowed, Response{false, "POSTメソッドを使用してください"})
+		return
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || a.Sessions == nil {
+		JSONResponse(w, http.StatusOK, Response{true, "既にログアウトしています"})
+		return
+	}
+
+	// セッションを削除
+	a.Sessions.Delete(cookie.Value)
+
+	clearSessionCookie(w)
+
+	log.Printf("ログアウト: セッションID %.16s...", cookie.Value)
+	JSONResponse(w, http.StatusOK, Response{true, "ログアウトしました"})
+}
+
+// Cookieを削除
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:    sessionCookieName,
+		Value:   "",
+		Path:    "/",
+		Expires: time.Unix(0, 0),
+		MaxAge:  -1,
+	})
+}
+
+// メールアドレスの確認。
+// GET（メールのリンク）では確認ボタンのページを返すだけで、確認は POST で行う。
+// メールのセキュリティ製品はリンクを先に開いて調べることがあり、GET で確認するとトークンが勝手に使われてしまうため
+func (a *API) HandleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if a.Verifier == nil {
+		JSONResponse(w, http.StatusNotFound, Response{false, "メールアドレスの確認は無効です"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		verifyPage.Execute(w, r.URL.Query().Get("token"))
+		return
+	case http.MethodPost:
+	default:
+		JSONResponse(w, http.StatusMethodNotAllowed, Response{false, "GETかPOSTメソッドを使用してください"})
+		return
+	}
+
+	var req EmailRequest
+	if err := decodeJSONOrForm(r, &req); err != nil {
+		JSONResponse(w, http.StatusBadRequest, Response{false, "リクエストを読めません"})
+		return
+	}
+
+	user, err := a.Verifier.Verify(req.Token)
+	if err != nil {
+		JSONResponse(w, http.StatusBadRequest, Response{false, err.Error()})
+		return
+	}
+	log.Printf("メールアドレスを確認: %s", user.Username)
+	JSONResponse(w, http.StatusOK, Response{true, "メールアドレスを確認しました。ログインできます"})
+}
+
+var verifyPage = template.Must(template.New("verify").Parse(`<!DOCTYPE html>
+<meta charset="utf-8">
+<title>メールアドレスの確認</title>
+<form method="POST" action="/email/verify">
+  <input type="hidden" name="token" value="{{.}}">
+  <button type="submit">メールアドレスを確認する</button>
+</form>
+`))
+
+// 確認メールの再送（登録されているかどうかに関わらず同じ返事をする）
+func (a *API) HandleResendVerification(w http.ResponseWriter, r *http.Request) {
+	if a.Verifier == nil {
+		JSONResponse(w, http.StatusNotFound, Response{false, "メールアドレスの確認は無効です"})
+		return
+	}
+	if r.Method != http.MethodPost {
+		JSONResponse(w, http.StatusMethodNotAllowed, Response{false, "POSTメソッドを使用してください"})
+		return
+	}
+
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		JSONResponse(w, http.StatusBadRequest, Response{false, "無効なJSONです"})
+		return
+	}
+	// 送るのは登録されているアドレスのときだけなので、待つと応答時間の差でわかってしまう。送信は裏で行う
+	// （同じアドレスへの間隔が短すぎる・送信待ちがいっぱいなら送らないが、返事は同じ）
+	a.mailQueue().Enqueue(TokenPurposeVerifyEmail, req.Email, func() {
+		if err := a.Verifier.Resend(req.Email); err != nil {
+			log.Printf("確認メールの再送に失敗: %v", err)
+		}
+	})
+	JSONResponse(w, http.StatusAccepted, Response{true, "未確認のアドレスが登録されていれば、確認メールを送りました"})
+}
+
+// 返事を変えると区別がつくので、送れなくても記録だけする（/email/resend で送り直せる）
+func (a *API) enqueueRegisterMail(email string, send func() error) {
+	queued := a.mailQueue().Enqueue(TokenPurposeVerifyEmail, email, func() {
+		if err := send(); err != nil {
+			log.Printf("登録のメールを送れません: %v", err)
+		}
+	})
+	if !queued {
+		log.Printf("登録のメールを送信待ちに入れられません（同じアドレスへの間隔が短いか、送信待ちがいっぱい）")
+	}
+}
+
+func (a *API) mailQueue() *MailQueue {
+	a.mailOnce.Do(func() {
+		if a.Mail == nil {
+			a.Mail = NewMailQueueFromConfig(DefaultConfig().Email)
+		}
+	})
+	return a.Mail
+}
+
+// パスワードを忘れたとき。登録されているかどうかに関わらず同じ返事をする
+func (a *API) HandleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if a.Resetter == nil {
+		JSONResponse(w, http.StatusNotFound, Response{false, "パスワードリセットは無効です"})
+		return
+	}
+	if r.Method != http.MethodPost {
+		JSONResponse(w, http.StatusMethodNotAllowed, Response{false, "POSTメソッドを使用してください"})
+		return
+	}
+
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		JSONResponse(w, http.StatusBadRequest, Response{false, "無効なJSONです"})
+		return
+	}
+	// 確認メールの再送と同じく、送信は裏で行う（応答時間の差で登録の有無がわからないように）
+	a.mailQueue().Enqueue(TokenPurposeResetPassword, req.Email, func() {
+		if err := a.Resetter.Forgot(req.Email); err != nil {
+			log.Printf("リセットメールの送信に失敗: %v", err)
+		}
+	})
+	JSONResponse(w, http.StatusAccepted, Response{true, "登録されているアドレスなら、リセット用のメールを送りました"})
+}
+
+// パスワードのリセット。
+// GET（メールのリンク）では新しいパスワードの入力ページを返すだけで、リセットは POST で行う（HandleVerifyEmail と同じ理由）
+func (a *API) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	if a.Resetter == nil {
+		JSONResponse(w, http.StatusNotFound, Response{false, "パスワードリセットは無効です"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		resetPage.Execute(w, r.URL.Query().Get("token"))
+		return
+	case http.MethodPost:
+	default:
+		JSONResponse(w, http.StatusMethodNotAllowed, Response{false, "GETかPOSTメソッドを使用してください"})
+		return
+	}
+
+	var req ResetPasswordRequest
+	if err := decodeJSONOrForm(r, &req); err != nil {
+		JSONResponse(w, http.StatusBadRequest, Response{false, "リクエストを読めません"})
+		return
+	}
+
+	if err := a.Resetter.Reset(req.Token, req.NewPassword); err != nil {
+		if overloadedResponse(w, err) {
+			return
+		}
+		if violations, ok := PolicyViolations(err); ok {
+			JSONResponse(w, http.StatusBadRequest, RegisterErrorResponse{false, err.Error(), violations})
+			return
+		}
+		if errors.Is(err, ErrPasswordChanged) {
+			JSONResponse(w, http.StatusConflict, Response{false, err.Error()})
+			return
+		}
+		JSONResponse(w, http.StatusBadRequest, Response{false, err.Error()})
+		return
+	}
+	JSONResponse(w, http.StatusOK, Response{true, "パスワードを設定し直しました。新しいパスワードでログインしてください"})
+}
+
+var resetPage = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<meta charset="utf-8">
+<title>パスワードのリセット</title>
+<form method="POST" action="/password/reset">
+  <input type="hidden" name="token" value="{{.}}">
+  <label>新しいパスワード <input type="password" name="new_password" autocomplete="new-password"></label>
+  <button type="submit">設定する</button>
+</form>
+`))
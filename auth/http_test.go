@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func postJSON(handler http.HandlerFunc, path string, body any) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// セッションを持たないサーバーの /password/change（ユーザー名をボディで送る）でも、
+// ログインと同じ試行の制限がかかる。制限中は正しいパスワードでも変更できず、ログインも待たされる
+func TestChangePasswordIsThrottled(t *testing.T) {
+	users := newTestStore(t, NewBcryptHasher(bcrypt.MinCost, false))
+	if err := users.Register("taro", "", "correct-password"); err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig().Lockout
+	api := &API{Users: users, Throttle: NewLoginThrottle(cfg)}
+
+	for i := range cfg.FreeAttempts {
+		rec := postJSON(api.HandleChangePassword, "/password/change",
+			ChangePasswordRequest{Username: "Taro", CurrentPassword: "wrong-password", NewPassword: "new-password-1"})
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%d 回目: status = %d, want 401", i+1, rec.Code)
+		}
+	}
+
+	rec := postJSON(api.HandleChangePassword, "/password/change",
+		ChangePasswordRequest{Username: "taro", CurrentPassword: "correct-password", NewPassword: "new-password-1"})
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("制限を超えた変更: status = %d, want 429 + Retry-After", rec.Code)
+	}
+	rec = postJSON(api.HandleLogin, "/login", AuthRequest{Username: "taro", Password: "correct-password"})
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("変更で失敗した後のログイン: status = %d, want 429", rec.Code)
+	}
+	if _, err := users.Authenticate("taro", "correct-password"); err != nil {
+		t.Fatalf("パスワードが変わってしまった: %v", err)
+	}
+}
+
+// パスワードを変更したのと同じ秒に発行された JWT も、変更の後は使えない。変更後にログインし直せば使える
+func TestJWTRejectedAfterPasswordChangeInSameSecond(t *testing.T) {
+	users := newTestStore(t, NewBcryptHasher(bcrypt.MinCost, false))
+	if err := users.Register("taro", "", "correct-password"); err != nil {
+		t.Fatal(err)
+	}
+	api := &API{Users: users, Tokens: NewHS256Issuer([]byte("test-secret"), time.Hour)}
+	login := func(password string) string {
+		t.Helper()
+		rec := postJSON(api.HandleLogin, "/login", AuthRequest{Username: "taro", Password: password})
+		var resp LoginResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Token == "" {
+			t.Fatalf("ログインできない: status = %d, err = %v", rec.Code, err)
+		}
+		return resp.Token
+	}
+	currentUser := func(token string) error {
+		r := httptest.NewRequest(http.MethodGet, "/profile", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		_, err := api.CurrentUser(r)
+		return err
+	}
+
+	old := login("correct-password")
+	if err := users.ChangePassword("taro", "correct-password", "changed-password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := currentUser(old); err == nil {
+		t.Fatal("変更前に発行されたトークンが使える")
+	}
+	if err := currentUser(login("changed-password")); err != nil {
+		t.Fatalf("変更後に発行されたトークンが使えない: %v", err)
+	}
+}
+
+// セッション Cookie は SameSite=Lax。Cookie で認証して状態を変えるルートは、
+// 別のサイトのフォームから送れる Content-Type を 415 で断り、何も変えない
+func TestStateChangingRoutesRequireJSON(t *testing.T) {
+	users := newTestStore(t, NewBcryptHasher(bcrypt.MinCost, false))
+	api := &API{Users: users, Sessions: NewSessionStore()}
+	registerWithRoles(t, users, "admin", RoleUser, RoleAdmin)
+	taro := registerWithRoles(t, users, "taro", RoleUser)
+	registerWithRoles(t, users, "hanako", RoleUser)
+	login := func(username string) *http.Cookie {
+		t.Helper()
+		rec := postJSON(api.HandleLogin, "/login", AuthRequest{Username: username, Password: "password-" + username})
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == sessionCookieName {
+				if c.SameSite != http.SameSiteLaxMode {
+					t.Errorf("SameSite = %v, want Lax", c.SameSite)
+				}
+				return c
+			}
+		}
+		t.Fatalf("%s: セッション Cookie がない (status %d)", username, rec.Code)
+		return nil
+	}
+	admin, hanako := login("admin"), login("hanako")
+
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		path    string
+		cookie  *http.Cookie
+		body    string
+	}{
+		{"rename", api.Require(PermUsersWrite, api.HandleAdminRenameUser), "/admin/users/{id}/rename", admin, `{"username":"jiro"}`},
+		{"suspend", api.Require(PermUsersWrite, api.HandleAdminSuspendUser), "/admin/users/{id}/suspend", admin, `{"reason":"test"}`},
+		{"reset-password", api.Require(PermUsersWrite, api.HandleAdminForcePasswordReset), "/admin/users/{id}/reset-password", admin, ``},
+		{"account delete", api.Require(PermAccountDelete, api.HandleAccountDelete), "/account/delete", hanako, `{"password":"password-hanako"}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST "+tc.path, tc.handler)
+			send := func(contentType, body string) int {
+				req := httptest.NewRequest(http.MethodPost, strings.Replace(tc.path, "{id}", taro.ID, 1), strings.NewReader(body))
+				req.Header.Set("Content-Type", contentType)
+				req.AddCookie(tc.cookie)
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, req)
+				return rec.Code
+			}
+			before, _ := users.GetByID(taro.ID)
+			for _, contentType := range []string{"application/x-www-form-urlencoded", "text/plain", "multipart/form-data; boundary=x", ""} {
+				if code := send(contentType, tc.body); code != http.StatusUnsupportedMediaType {
+					t.Errorf("Content-Type %q: status = %d, want 415", contentType, code)
+				}
+			}
+			if after, _ := users.GetByID(taro.ID); after.Username != before.Username || after.State != before.State ||
+				after.PasswordResetRequired != before.PasswordResetRequired {
+				t.Fatal("断ったリクエストで変わった")
+			}
+			if _, err := users.Get("hanako"); err != nil {
+				t.Fatalf("断ったリクエストで削除された: %v", err)
+			}
+			if code := send("application/json; charset=utf-8", tc.body); code != http.StatusOK {
+				t.Errorf("JSON: status = %d, want 200", code)
+			}
+		})
+	}
+}
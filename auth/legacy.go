@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ===================
+// 旧システムのハッシュ移行
+// ===================
+
+// 旧システムは hash(パスワード + ソルト) の形式で保存していた（01_hash_and_salt の salt() と同じ）。
+// そのまま保存すると弱いハッシュがDBに残るので、取り込む時点で bcrypt で包む（オニオンハッシュ）。
+//
+//	$legacy-sha256$<ソルト(base64)>$2a$10$...
+//	                                └─ bcrypt(hex(sha256(パスワード + ソルト)))
+//
+// 次回ログイン成功時に NeedsRehash が true を返すので、通常の形式へ作り直される。
+
+// 旧システムで使われていたダイジェスト
+var legacyDigests = map[string]func([]byte) []byte{
+	"md5": func(b []byte) []byte {
+		sum := md5.Sum(b)
+		return sum[:]
+	},
+	"sha1": func(b []byte) []byte {
+		sum := sha1.Sum(b)
+		return sum[:]
+	},
+	"sha256": func(b []byte) []byte {
+		sum := sha256.Sum256(b)
+		return sum[:]
+	},
+}
+
+// オニオンハッシュの検証専用。新規ハッシュは作らない
+type LegacyOnionHasher struct{}
+
+func NewLegacyOnionHasher() *LegacyOnionHasher {
+	return &LegacyOnionHasher{}
+}
+
+func (h *LegacyOnionHasher) Hash(password string) ([]byte, error) {
+	return nil, fmt.Errorf("旧形式のハッシュは新規に作成できません")
+}
+
+func (h *LegacyOnionHasher) Verify(hash []byte, password string) error {
+	algorithm, salt, inner, err := parseLegacyOnion(hash)
+	if err != nil {
+		return err
+	}
+	// 旧システムと同じ計算でダイジェストを作り、それを bcrypt で検証する
+	digest := legacyDigests[algorithm]([]byte(password + salt))
+	err = bcrypt.CompareHashAndPassword(inner, []byte(hex.EncodeToString(digest)))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+func (h *LegacyOnionHasher) CanVerify(hash []byte) bool {
+	return strings.HasPrefix(string(hash), "$legacy-")
+}
+
+// 旧形式は常に通常の形式へ移行させる
+func (h *LegacyOnionHasher) NeedsRehash(hash []byte) bool {
+	return true
+}
+
+// 旧システムのダイジェスト（hex）を bcrypt で包んでオニオンハッシュにする
+func WrapLegacyHash(algorithm, salt, digestHex string, cost int) ([]byte, error) {
+	algorithm = strings.ToLower(algorithm)
+	digestFunc, ok := legacyDigests[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("未対応の旧アルゴリズムです: %s", algorithm)
+	}
+
+	digest, err := hex.DecodeString(strings.TrimSpace(digestHex))
+	if err != nil {
+		return nil, fmt.Errorf("ダイジェストがhexではありません: %w", err)
+	}
+	if len(digest) != len(digestFunc(nil)) {
+		return nil, fmt.Errorf("%s のダイジェスト長が不正です: %dバイト", algorithm, len(digest))
+	}
+
+	// hexを小文字に揃えてから包む（検証時も小文字のhexで比較するため）
+	inner, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(digest)), cost)
+	if err != nil {
+		return nil, err
+	}
+	encoded := fmt.Sprintf("$legacy-%s$%s%s", algorithm, base64.RawStdEncoding.EncodeToString([]byte(salt)), inner)
+	return []byte(encoded), nil
+}
+
+// オニオンハッシュからアルゴリズム・ソルト・内側のbcryptハッシュを取り出す
+func parseLegacyOnion(hash []byte) (string, string, []byte, error) {
+	// "" / "legacy-sha256" / ソルト / bcryptハッシュ（先頭の$を除く）
+	parts := strings.SplitN(string(hash), "$", 4)
+	if len(parts) != 4 || !strings.HasPrefix(parts[1], "legacy-") {
+		return "", "", nil, fmt.Errorf("旧形式のハッシュ形式が不正です")
+	}
+
+	algorithm := strings.TrimPrefix(parts[1], "legacy-")
+	if _, ok := legacyDigests[algorithm]; !ok {
+		return "", "", nil, fmt.Errorf("未対応の旧アルゴリズムです: %s", algorithm)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", "", nil, fmt.Errorf("ソルトのデコードに失敗: %w", err)
+	}
+	return algorithm, string(salt), []byte("$" + parts[3]), nil
+}
+
+// --- インポート ---
+
+// 旧システムからのダンプの1行
+type LegacyRecord struct {
+	Username  string `json:"username"`
+	Algorithm string `json:"algorithm"` // md5 / sha1 / sha256
+	Salt      string `json:"salt"`      // ソルトなしなら空
+	Hash      string `json:"hash"`      // hex
+}
+
+// CSV（username,algorithm,salt,hash のヘッダー付き）か JSON配列のダンプを読み込む
+func ReadLegacyDump(path string) ([]LegacyRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var records []LegacyRecord
+		if err := json.NewDecoder(f).Decode(&records); err != nil {
+			return nil, fmt.Errorf("JSONのパースに失敗: %w", err)
+		}
+		return records, nil
+	case ".csv":
+		return readLegacyCSV(f)
+	default:
+		return nil, fmt.Errorf("未対応のファイル形式です（.csv か .json）: %s", path)
+	}
+}
+
+func readLegacyCSV(r io.Reader) ([]LegacyRecord, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("CSVのパースに失敗: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	// ヘッダーから列の位置を決める
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	for _, name := range []string{"username", "algorithm", "salt", "hash"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSVに %s 列がありません", name)
+		}
+	}
+
+	records := make([]LegacyRecord, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		records = append(records, LegacyRecord{
+			Username:  row[columns["username"]],
+			Algorithm: row[columns["algorithm"]],
+			Salt:      row[columns["salt"]],
+			Hash:      row[columns["hash"]],
+		})
+	}
+	return records, nil
+}
+
+// ダンプを読み込み、オニオンハッシュに包んで UserStore に登録する。
+// 取り込めなかった行はスキップしてエラーにまとめる
+func ImportLegacyUsers(store *UserStore, path string, cost int) (int, error) {
+	records, err := ReadLegacyDump(path)
+	if err != nil {
+		return 0, err
+	}
+
+	imported := 0
+	var errs []error
+	for i, rec := range records {
+		hash, err := WrapLegacyHash(rec.Algorithm, rec.Salt, rec.Hash, cost)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%d件目 (%s): %w", i+1, rec.Username, err))
+			continue
+		}
+		if err := store.Import(rec.Username, hash); err != nil {
+			errs = append(errs, fmt.Errorf("%d件目 (%s): %w", i+1, rec.Username, err))
+			continue
+		}
+		imported++
+	}
+	return imported, errors.Join(errs...)
+}
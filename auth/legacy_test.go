@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 旧システムと同じ計算（hex(digest(パスワード + ソルト))）
+func legacyDigestHex(algorithm, password, salt string) string {
+	data := []byte(password + salt)
+	switch algorithm {
+	case "md5":
+		sum := md5.Sum(data)
+		return hex.EncodeToString(sum[:])
+	case "sha1":
+		sum := sha1.Sum(data)
+		return hex.EncodeToString(sum[:])
+	default:
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+}
+
+// 旧システムのダイジェストを包んだオニオンハッシュは、元のパスワードで検証でき、常に作り直しになる
+func TestLegacyOnionHashVerifies(t *testing.T) {
+	h := NewLegacyOnionHasher()
+	for _, tc := range []struct {
+		algorithm, salt string
+	}{
+		{"md5", "s4lt"},
+		{"sha1", ""},
+		{"sha256", "日本語のソルト"},
+	} {
+		// 大文字の hex でも取り込める（小文字に揃えて包む）
+		digest := strings.ToUpper(legacyDigestHex(tc.algorithm, "secret-password", tc.salt))
+		hash, err := WrapLegacyHash(strings.ToUpper(tc.algorithm), tc.salt, digest, bcrypt.MinCost)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.algorithm, err)
+		}
+		if !h.CanVerify(hash) || !bytes.HasPrefix(hash, []byte("$legacy-"+tc.algorithm+"$")) {
+			t.Fatalf("%s: 旧形式の目印がない: %s", tc.algorithm, hash)
+		}
+		if err := h.Verify(hash, "secret-password"); err != nil {
+			t.Errorf("%s: 正しいパスワードで検証できない: %v", tc.algorithm, err)
+		}
+		if err := h.Verify(hash, "wrong-password"); !errors.Is(err, ErrPasswordMismatch) {
+			t.Errorf("%s: 違うパスワードで err = %v, want ErrPasswordMismatch", tc.algorithm, err)
+		}
+		if !h.NeedsRehash(hash) {
+			t.Errorf("%s: 作り直しにならない", tc.algorithm)
+		}
+	}
+	if _, err := h.Hash("secret-password"); err == nil {
+		t.Error("旧形式で新しいハッシュが作れてしまう")
+	}
+}
+
+// 未対応のアルゴリズム・hex でない・長さが違うダイジェストは取り込まない
+func TestWrapLegacyHashRejectsInvalidDigest(t *testing.T) {
+	for _, tc := range []struct {
+		name, algorithm, digest string
+	}{
+		{"未対応のアルゴリズム", "crc32", "deadbeef"},
+		{"hex でない", "md5", "not-a-hex-digest"},
+		{"長さが違う", "sha256", legacyDigestHex("md5", "secret-password", "")},
+	} {
+		if _, err := WrapLegacyHash(tc.algorithm, "", tc.digest, bcrypt.MinCost); err == nil {
+			t.Errorf("%s: エラーにならない", tc.name)
+		}
+	}
+}
+
+// ダンプから取り込んだユーザーは、ログインに成功すると通常の形式へ移行する。
+// 取り込めない行は飛ばして、エラーにまとめて返す
+func TestImportLegacyUsersMigratesOnLogin(t *testing.T) {
+	csv := "username,algorithm,salt,hash\n" +
+		"taro,sha256,abc," + legacyDigestHex("sha256", "taro-password", "abc") + "\n" +
+		"hanako,md5,," + legacyDigestHex("md5", "hanako-password", "") + "\n" +
+		"broken,sha256,,zzzz\n"
+	path := filepath.Join(t.TempDir(), "dump.csv")
+	if err := os.WriteFile(path, []byte(csv), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hasher := NewMultiHasher(NewBcryptHasher(bcrypt.MinCost, false), NewLegacyOnionHasher())
+	store := newTestStore(t, hasher)
+	imported, err := ImportLegacyUsers(store, path, bcrypt.MinCost)
+	if imported != 2 {
+		t.Fatalf("imported = %d, want 2", imported)
+	}
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("取り込めなかった行がエラーにない: %v", err)
+	}
+
+	if _, err := store.Authenticate("taro", "wrong-password"); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("err = %v, want ErrAuthFailed", err)
+	}
+	if _, err := store.Authenticate("taro", "taro-password"); err != nil {
+		t.Fatalf("取り込んだユーザーでログインできない: %v", err)
+	}
+	user, err := store.Get("taro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(user.PasswordHash, []byte("$2a$")) {
+		t.Fatalf("ログイン後も旧形式のまま: %s", user.PasswordHash)
+	}
+	if _, err := store.Authenticate("taro", "taro-password"); err != nil {
+		t.Fatalf("移行後のハッシュでログインできない: %v", err)
+	}
+
+	// 同じダンプをもう一度取り込んでも、既存のユーザーは上書きしない
+	if imported, _ := ImportLegacyUsers(store, path, bcrypt.MinCost); imported != 0 {
+		t.Errorf("2回目の imported = %d, want 0", imported)
+	}
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// ===================
+// アカウントの状態
+// ===================
+
+// アカウントは次の状態を移る。ログインできるのは active だけ。
+//
+//	pending ──(メールアドレスを確認)──→ active ←──(管理者が戻す)──→ suspended
+//	   │                                  │                              │
+//	   └──────────────────────────────→ deactivated ←───────────────────┘
+//	                                      │  （猶予期間が過ぎたら削除ジョブが完全に消す）
+//	                                      └──(猶予期間中なら管理者が戻せる)──→ active
+//
+// 状態が変わるたびに監査ログ（AuditLog）に残す。
+
+type AccountState string
+
+const (
+	StatePending     AccountState = "pending"     // メールアドレスの確認待ち（メール確認が有効なときだけ）
+	StateActive      AccountState = "active"      // 使える
+	StateSuspended   AccountState = "suspended"   // 管理者が停止した
+	StateDeactivated AccountState = "deactivated" // 退会した（猶予期間の後に完全に消える）
+)
+
+// 移れる状態
+var stateTransitions = map[AccountState][]AccountState{
+	StatePending:     {StateActive, StateSuspended, StateDeactivated},
+	StateActive:      {StateSuspended, StateDeactivated},
+	StateSuspended:   {StateActive, StateDeactivated},
+	StateDeactivated: {StateActive},
+}
+
+var ErrInvalidTransition = errors.New("その状態には変更できません")
+
+// ログインを断るときに、どの状態でも返事を同じにする
+// （停止中・退会済みなどの区別を、パスワードを知っている人にも見せない）
+var ErrAccountUnavailable = errors.New("このアカウントは現在ログインできません")
+
+// ログインを断った理由。Error() はどの状態でも ErrAccountUnavailable と同じ文面で、
+// 本当の理由（Reason）はサーバーのログにだけ出す
+type AccountStateError struct {
+	State  AccountState
+	Reason string
+}
+
+func (e *AccountStateError) Error() string { return ErrAccountUnavailable.Error() }
+func (e *AccountStateError) Unwrap() error { return ErrAccountUnavailable }
+
+// ログインしてよい状態か（Authenticate と、ログイン中のリクエストの両方で見る）
+func (s *UserStore) checkState(user *User) error {
+	switch user.State {
+	case StateActive:
+	case StatePending:
+		// メール確認を無効にしているなら、確認待ちのままでもログインできる
+		if s.verifyEmail {
+			return &AccountStateError{StatePending, "メールアドレスが未確認"}
+		}
+	case StateSuspended:
+		return &AccountStateError{StateSuspended, fmt.Sprintf("管理者が停止中（%s から）", user.StateChangedAt.Format(time.DateTime))}
+	case StateDeactivated:
+		return &AccountStateError{StateDeactivated, fmt.Sprintf("退会済み（%s に退会。完全な削除を待っている）", user.StateChangedAt.Format(time.DateTime))}
+	default:
+		return &AccountStateError{user.State, "不明な状態"}
+	}
+	if user.PasswordResetRequired {
+		return ErrPasswordResetRequired
+	}
+	return nil
+}
+
+// 状態を変える（同じ状態なら何もしない）。actor は操作した人、reason は監査ログに残す理由
+func (s *UserStore) Transition(id string, to AccountState, actor, reason string) (*User, error) {
+	var from AccountState
+	user, err := s.modifyIf(id, func(user *User) (bool, error) {
+		from = user.State
+		if from == to {
+			return false, nil
+		}
+		if !slices.Contains(stateTransitions[from], to) {
+			return false, fmt.Errorf("%w: %s → %s", ErrInvalidTransition, from, to)
+		}
+		setState(user, to)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if from != to {
+		s.recordAudit(AuditEvent{Type: AuditStateChanged, UserID: user.ID, Username: user.Username,
+			Actor: actor, From: from, To: to, Reason: reason})
+	}
+	return user, nil
+}
+
+func setState(user *User, to AccountState) {
+	user.State = to
+	user.StateChangedAt = time.Now()
+}
+
+// メールアドレスの確認が済んだら、確認待ちを使える状態にする（呼び出し側で Update する）。
+// 監査ログに残すイベントを返す（変わらなければ nil）
+func activatePending(user *User) *AuditEvent {
+	if user.State != StatePending {
+		return nil
+	}
+	setState(user, StateActive)
+	return &AuditEvent{Type: AuditStateChanged, UserID: user.ID, Username: user.Username,
+		Actor: ActorSelf, From: StatePending, To: StateActive, Reason: "メールアドレスを確認"}
+}
+
+// --- 削除ジョブ ---
+
+// 退会（deactivated）から猶予期間が過ぎたアカウントを、定期的に完全に消す。
+// 猶予期間の間は管理者が元に戻せる（間違って退会した・乗っ取られて退会させられた場合のため）
+type AccountPurger struct {
+	users    *UserStore
+	sessions SessionRepository      // nilならセッションを持たない
+	tokens   OneTimeTokenRepository // nilなら使い捨てトークンを持たない
+	history  LoginHistoryRepository // nilならログイン履歴を持たない
+	grace    time.Duration
+	interval time.Duration
+}
+
+func NewAccountPurger(users *UserStore, sessions SessionRepository, tokens OneTimeTokenRepository, history LoginHistoryRepository, grace, interval time.Duration) *AccountPurger {
+	return &AccountPurger{users: users, sessions: sessions, tokens: tokens, history: history, grace: grace, interval: interval}
+}
+
+// 裏で interval ごとに PurgeOnce を回す。返した関数を呼ぶと止まる
+func (p *AccountPurger) Start() (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			if _, err := p.PurgeOnce(); err != nil {
+				log.Printf("退会したアカウントの削除に失敗: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// 猶予期間が過ぎたアカウントを全て消し、消した数を返す
+func (p *AccountPurger) PurgeOnce() (int, error) {
+	const batch = 100
+	cutoff := time.Now().Add(-p.grace)
+	n := 0
+	for {
+		// 消した分だけ一覧から外れるので、いつも先頭から読む
+		users, _, err := p.users.List(UserQuery{State: StateDeactivated, ChangedBefore: cutoff, Limit: batch})
+		if err != nil {
+			return n, err
+		}
+		for _, user := range users {
+			deleted, err := p.purge(user)
+			if err != nil {
+				return n, err
+			}
+			if deleted {
+				n++
+			}
+		}
+		if len(users) < batch {
+			break
+		}
+	}
+	if n > 0 {
+		log.Printf("退会から %v 過ぎたアカウントを %d 件削除しました", p.grace, n)
+	}
+	return n, nil
+}
+
+// 消したら true（別の削除ジョブが先に消した・元に戻されていたら false）
+func (p *AccountPurger) purge(user *User) (bool, error) {
+	// 一覧を読んでから消すまでの間に、管理者が元に戻しているかもしれない
+	err := p.users.deleteIf(user.ID, ActorSystem, "退会後の猶予期間が過ぎた", func(current *User) error {
+		if current.State != StateDeactivated || current.StateChangedAt.After(time.Now().Add(-p.grace)) {
+			return errSkipDelete
+		}
+		return nil
+	})
+	if errors.Is(err, ErrUserNotFound) || errors.Is(err, errSkipDelete) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// SQLite は外部キーで一緒に消えるが、インメモリは別に持っているので消しておく
+	if p.sessions != nil {
+		p.sessions.DeleteUser(user.ID)
+	}
+	if p.tokens != nil {
+		p.tokens.DeleteUser(user.ID, TokenPurposeVerifyEmail)
+		p.tokens.DeleteUser(user.ID, TokenPurposeResetPassword)
+	}
+	if p.history != nil {
+		p.history.DeleteUser(user.ID)
+	}
+	return true, nil
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===================
+// メール送信
+// ===================
+
+// 確認メールなどは Mailer 経由で送る。
+// 開発中は本物のメールサーバーがなくても動くように、送るはずのメールをファイルに書き出す（FileOutbox）。
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// メール1通分のテキスト（ヘッダー + 本文）を作る
+func formatMessage(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject)) // 日本語の件名はエンコードが必要
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// --- ファイルに書き出す（開発・動作確認用） ---
+
+// 送るはずのメールを Dir に .eml ファイルとして書き出す。メールソフトでそのまま開ける
+type FileOutbox struct {
+	Dir  string
+	From string
+	seq  atomic.Int64 // 同じ時刻に送ってもファイル名がぶつからないように
+}
+
+func NewFileOutbox(dir, from string) *FileOutbox {
+	return &FileOutbox{Dir: dir, From: from}
+}
+
+func (o *FileOutbox) Send(msg Message) error {
+	if err := os.MkdirAll(o.Dir, 0o700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%03d-%s.eml", time.Now().Format("20060102-150405"), o.seq.Add(1)%1000, sanitizeFileName(msg.To))
+	return os.WriteFile(filepath.Join(o.Dir, name), formatMessage(o.From, msg), 0o600)
+}
+
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// --- SMTP ---
+
+type SMTPMailer struct {
+	Addr string // "host:port"
+	From string
+	Auth smtp.Auth // nilなら認証しない
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{msg.To}, formatMessage(m.From, msg))
+}
+
+// --- メールアドレス ---
+
+var ErrInvalidEmail = errors.New("メールアドレスの形式が正しくありません")
+
+// メールアドレスを確かめて、前後の空白を除き小文字にそろえる。
+// 厳密にはローカル部（@ の前）の大文字小文字は区別されうるが、実際に区別するサーバーはまずないので、同じアドレスとみなす
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	// "太郎 <taro@example.com>" のような表示名付きは受け付けない
+	if err != nil || addr.Address != email {
+		return "", ErrInvalidEmail
+	}
+	return strings.ToLower(email), nil
+}
+
+// --- 裏で送るメールの順番待ち ---
+
+// 登録・確認メールの再送・パスワードリセットのメールは、応答の後に裏で送る（応答時間の差で登録の有無がわからないように）。
+// リクエストごとに goroutine を作ると、1つのクライアントからメールサーバーや他人の受信箱をいくらでもあふれさせられるので、
+//   - 送るのは決まった数のワーカーだけ。待ちがいっぱいなら捨てる
+//   - 同じ用途・同じアドレス（正規化したもの）へは interval に1回だけ
+//
+// 登録されているかどうかを見る前に数えるので、捨てられたかどうかからも登録の有無はわからない
+type MailQueue struct {
+	jobs     chan func()
+	interval time.Duration
+
+	mu        sync.Mutex
+	last      map[string]time.Time // key: 用途 + 正規化したアドレス。最後に受け付けた日時
+	lastSweep time.Time
+}
+
+func NewMailQueue(workers, size int, interval time.Duration) *MailQueue {
+	q := &MailQueue{
+		jobs:     make(chan func(), max(size, 0)),
+		interval: interval,
+		last:     make(map[string]time.Time),
+	}
+	for range max(workers, 1) {
+		go func() {
+			for send := range q.jobs {
+				send()
+			}
+		}()
+	}
+	return q
+}
+
+// send を順番待ちに入れる。アドレスとして正しくない・間隔が短すぎる・待ちがいっぱいなら入れずに false
+func (q *MailQueue) Enqueue(purpose, email string, send func()) bool {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return false
+	}
+	if !q.allow(purpose + " " + email) {
+		return false
+	}
+	select {
+	case q.jobs <- send:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *MailQueue) allow(key string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	// 間隔を過ぎたものは覚えておく必要がないので、ときどき掃除する（いろいろなアドレスで送られても増え続けない）
+	if now.Sub(q.lastSweep) > q.interval {
+		for k, t := range q.last {
+			if now.Sub(t) >= q.interval {
+				delete(q.last, k)
+			}
+		}
+		q.lastSweep = now
+	}
+	if t, ok := q.last[key]; ok && now.Sub(t) < q.interval {
+		return false
+	}
+	q.last[key] = now
+	return true
+}
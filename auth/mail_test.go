@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// 同じ用途・同じアドレスへは間隔を空けないと受け付けない（大文字小文字の違いは同じアドレス）
+func TestMailQueueLimitsPerAddress(t *testing.T) {
+	q := NewMailQueue(1, 10, time.Hour)
+	sent := make(chan string, 10)
+	send := func(s string) func() { return func() { sent <- s } }
+
+	if !q.Enqueue("reset", "taro@example.com", send("first")) {
+		t.Fatal("最初のメールを受け付けない")
+	}
+	if q.Enqueue("reset", "Taro@Example.com", send("second")) {
+		t.Error("同じアドレスへのメールを続けて受け付けた")
+	}
+	if !q.Enqueue("verify", "taro@example.com", send("other purpose")) {
+		t.Error("用途が違うメールを受け付けない")
+	}
+	if q.Enqueue("reset", "not an address", send("invalid")) {
+		t.Error("アドレスでないものを受け付けた")
+	}
+	for range 2 {
+		select {
+		case <-sent:
+		case <-time.After(5 * time.Second):
+			t.Fatal("受け付けたメールが送られない")
+		}
+	}
+}
+
+// 送信待ちがいっぱいなら捨てる（goroutine を増やさない）
+func TestMailQueueDropsWhenFull(t *testing.T) {
+	q := NewMailQueue(1, 1, time.Hour)
+	block := make(chan struct{})
+	defer close(block)
+	started := make(chan struct{})
+
+	q.Enqueue("reset", "a@example.com", func() { close(started); <-block })
+	<-started // ワーカーは1つだけで、これで埋まる
+	if !q.Enqueue("reset", "b@example.com", func() {}) {
+		t.Fatal("待ちに空きがあるのに受け付けない")
+	}
+	if q.Enqueue("reset", "c@example.com", func() {}) {
+		t.Error("待ちがいっぱいなのに受け付けた")
+	}
+}
@@ -0,0 +1,369 @@
+package auth
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ===================
+// スキーマのマイグレーション
+// ===================
+
+// テーブル定義は migrations/ に番号付きの SQL ファイルとして置き、バイナリに埋め込む。
+//
+//	migrations/0001_create_users.up.sql     ← 適用するとき
+//	migrations/0001_create_users.down.sql   ← 取り消すとき
+//	migrations/0002_create_sessions.up.sql
+//	migrations/0002_create_sessions.down.sql
+//	migrations/0003_add_display_name.up.sql
+//
+// 適用済みの番号は schema_migrations テーブルに記録し、未適用のものだけを番号順に流す。
+// 一度リリースしたファイルは書き換えず、変更は新しい番号のファイルで行う。
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string // .down.sql がなければ空（取り消せない）
+}
+
+func (m migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// 埋め込んだ SQL ファイルを番号順に読み込む
+func loadMigrations() ([]migration, error) {
+	paths, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, path := range paths {
+		base := strings.TrimPrefix(path, "migrations/")
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction, base = "up", strings.TrimSuffix(base, ".up.sql")
+		case strings.HasSuffix(base, ".down.sql"):
+			direction, base = "down", strings.TrimSuffix(base, ".down.sql")
+		default:
+			return nil, fmt.Errorf("%s: ファイル名は 0001_name.up.sql / 0001_name.down.sql の形式にしてください", path)
+		}
+		num, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("%s: ファイル名は 0001_name.up.sql / 0001_name.down.sql の形式にしてください", path)
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("マイグレーションの番号 %d が重複しています: %s, %s", version, m.Name, name)
+		}
+
+		data, err := migrationFiles.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	var migrations []migration
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("%s: .up.sql がありません", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// --- 適用済みの記録とロック ---
+
+// マイグレーションの管理用テーブル（これ自体はマイグレーションでは作らない）
+func ensureMigrationTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER  PRIMARY KEY,
+			applied_at DATETIME NOT NULL
+		);
+		-- 行が1つだけ入る表。入っている間は他のプロセスがマイグレーションできない
+		CREATE TABLE IF NOT EXISTS schema_migrations_lock (
+			id        INTEGER  PRIMARY KEY CHECK (id = 1),
+			owner     TEXT     NOT NULL,
+			locked_at DATETIME NOT NULL
+		);`)
+	return err
+}
+
+// 適用済みの番号と日時
+func appliedMigrations(db *sql.DB) (map[int]time.Time, error) {
+	rows, err := db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var v int
+		var at time.Time
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		applied[v] = at
+	}
+	return applied, rows.Err()
+}
+
+// 別のプロセスがマイグレーション中のときのエラー
+type MigrationLockedError struct {
+	Owner    string
+	LockedAt time.Time
+}
+
+func (e *MigrationLockedError) Error() string {
+	return fmt.Sprintf("%s が %s からマイグレーション中です（止まったプロセスのロックなら migrate unlock で外せます）",
+		e.Owner, e.LockedAt.Format(time.DateTime))
+}
+
+// ロックの持ち主（ホスト名:PID）
+func lockOwner() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// ロックを取る。取れなければ MigrationLockedError。
+// 行の追加は PRIMARY KEY で1つしか成功しないので、同時に来ても片方だけが取れる
+func lockMigrations(db *sql.DB) (func(), error) {
+	owner := lockOwner()
+	_, err := db.Exec(`INSERT INTO schema_migrations_lock (id, owner, locked_at) VALUES (1, ?, ?)`, owner, time.Now())
+	if isUniqueViolation(err) {
+		locked := &MigrationLockedError{}
+		if err := db.QueryRow(`SELECT owner, locked_at FROM schema_migrations_lock WHERE id = 1`).
+			Scan(&locked.Owner, &locked.LockedAt); err != nil {
+			return nil, err
+		}
+		return nil, locked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		db.Exec(`DELETE FROM schema_migrations_lock WHERE id = 1 AND owner = ?`, owner)
+	}, nil
+}
+
+// 途中で落ちたプロセスが残したロックを外す
+func UnlockMigrations(db *sql.DB) (bool, error) {
+	if err := ensureMigrationTables(db); err != nil {
+		return false, err
+	}
+	res, err := db.Exec(`DELETE FROM schema_migrations_lock`)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// --- up / down / status ---
+
+type MigrateOptions struct {
+	DryRun bool      // 実行せず、流す SQL を Out に書き出すだけ
+	Out    io.Writer // 進み具合の出力先（nilなら出さない）
+}
+
+func (o MigrateOptions) printf(format string, args ...any) {
+	if o.Out != nil {
+		fmt.Fprintf(o.Out, format, args...)
+	}
+}
+
+// 未適用のマイグレーションを番号順に適用し、適用した数を返す。
+// 1つずつトランザクションで囲むので、途中で失敗してもそのマイグレーションは半端に残らない
+func MigrateUp(db *sql.DB, opts MigrateOptions) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if err := ensureMigrationTables(db); err != nil {
+		return 0, err
+	}
+	unlock, err := lockForRun(db, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	// ロックを取ってから読む（取る前に読むと、待っている間に他のプロセスが適用した分を二重に流す）
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, m := range migrations {
+		if _, done := applied[m.Version]; done {
+			continue
+		}
+		if err := runMigration(db, m, "up", opts); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// 適用済みのうち新しいものから steps 個を取り消し、取り消した数を返す
+func MigrateDown(db *sql.DB, steps int, opts MigrateOptions) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if err := ensureMigrationTables(db); err != nil {
+		return 0, err
+	}
+	unlock, err := lockForRun(db, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for i := len(migrations) - 1; i >= 0 && n < steps; i-- {
+		m := migrations[i]
+		if _, done := applied[m.Version]; !done {
+			continue
+		}
+		if m.Down == "" {
+			return n, fmt.Errorf("%s は取り消せません（.down.sql がありません）", m)
+		}
+		if err := runMigration(db, m, "down", opts); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// ドライランではロックを取らない（何も書き込まないので）
+func lockForRun(db *sql.DB, opts MigrateOptions) (func(), error) {
+	if opts.DryRun {
+		return func() {}, nil
+	}
+	return lockMigrations(db)
+}
+
+// 1つのマイグレーションを流し、記録を更新する
+func runMigration(db *sql.DB, m migration, direction string, opts MigrateOptions) error {
+	query := m.Up
+	if direction == "down" {
+		query = m.Down
+	}
+	if opts.DryRun {
+		opts.printf("-- %s (%s)\n%s\n", m, direction, strings.TrimSpace(query))
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("マイグレーション %s（%s）に失敗: %w", m, direction, err)
+	}
+	if direction == "up" {
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, m.Version, time.Now())
+	} else {
+		_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	opts.printf("%s %s\n", direction, m)
+	return nil
+}
+
+// マイグレーション1つ分の状態
+type MigrationStatus struct {
+	Version   int
+	Name      string // ファイルがなければ空（新しいバイナリで適用されたもの）
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// 埋め込んだマイグレーションとDBの記録を突き合わせる
+func MigrationStatuses(db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureMigrationTables(db); err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range migrations {
+		at, done := applied[m.Version]
+		statuses = append(statuses, MigrationStatus{Version: m.Version, Name: m.Name, Applied: done, AppliedAt: at})
+		delete(applied, m.Version)
+	}
+	for v, at := range applied {
+		statuses = append(statuses, MigrationStatus{Version: v, Applied: true, AppliedAt: at})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// ロックされていればその情報を返す
+func MigrationLock(db *sql.DB) (*MigrationLockedError, error) {
+	if err := ensureMigrationTables(db); err != nil {
+		return nil, err
+	}
+	locked := &MigrationLockedError{}
+	err := db.QueryRow(`SELECT owner, locked_at FROM schema_migrations_lock WHERE id = 1`).
+		Scan(&locked.Owner, &locked.LockedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return locked, nil
+}
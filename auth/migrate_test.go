@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 適用済みの一番新しい番号（何も適用されていなければ 0）
+func schemaVersion(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var v sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	return int(v.Int64)
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n > 0
+}
+
+func openTestDB(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := openSQLiteDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// 全部適用 → 2つ取り消し → 全部取り消し → もう一度全部適用、がどれも通る（.down.sql が全て正しい）
+func TestMigrateUpAndDown(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := migrations[len(migrations)-1].Version
+	db := openTestDB(t, filepath.Join(t.TempDir(), "auth.db"))
+
+	n, err := MigrateUp(db, MigrateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(migrations) || schemaVersion(t, db) != latest {
+		t.Fatalf("up: %d 個適用, version %d, want %d 個, %d", n, schemaVersion(t, db), len(migrations), latest)
+	}
+	if n, err := MigrateUp(db, MigrateOptions{}); err != nil || n != 0 {
+		t.Fatalf("2回目の up: %d, %v, want 0", n, err)
+	}
+
+	// 0009 まで取り消す
+	steps := latest - 8
+	if n, err := MigrateDown(db, steps, MigrateOptions{}); err != nil || n != steps {
+		t.Fatalf("down %d: %d, %v", steps, n, err)
+	}
+	if v := schemaVersion(t, db); v != 8 {
+		t.Fatalf("down %d の後の version = %d, want 8", steps, v)
+	}
+	if tableExists(t, db, "login_history") {
+		t.Error("0009 を取り消したのに login_history が残っている")
+	}
+
+	if n, err := MigrateDown(db, len(migrations), MigrateOptions{}); err != nil || n != len(migrations)-steps {
+		t.Fatalf("全部 down: %d, %v", n, err)
+	}
+	if v := schemaVersion(t, db); v != 0 || tableExists(t, db, "users") {
+		t.Fatalf("全部取り消した後: version %d, users あり=%v", v, tableExists(t, db, "users"))
+	}
+
+	if n, err := MigrateUp(db, MigrateOptions{}); err != nil || n != len(migrations) {
+		t.Fatalf("もう一度 up: %d, %v", n, err)
+	}
+	// 取り消しと適用をやり直しても、ユーザーを保存できる
+	if err := NewSQLiteUserRepository(db).Create(newRepoUser("taro", "taro@example.com")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ドライランは SQL を書き出すだけで、適用も記録もしない
+func TestMigrateDryRun(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "auth.db"))
+	var out bytes.Buffer
+	n, err := MigrateUp(db, MigrateOptions{DryRun: true, Out: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 || !strings.Contains(out.String(), "CREATE TABLE users") {
+		t.Fatalf("ドライランの出力 (%d 個):\n%s", n, out.String())
+	}
+	if v := schemaVersion(t, db); v != 0 || tableExists(t, db, "users") {
+		t.Fatalf("ドライランで適用された: version %d", v)
+	}
+
+	if _, err := MigrateUp(db, MigrateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	before := schemaVersion(t, db)
+	out.Reset()
+	if n, err := MigrateDown(db, 1, MigrateOptions{DryRun: true, Out: &out}); err != nil || n != 1 {
+		t.Fatalf("down のドライラン: %d, %v", n, err)
+	}
+	if schemaVersion(t, db) != before || !strings.Contains(out.String(), "(down)") {
+		t.Fatalf("down のドライランで取り消された、または出力がない:\n%s", out.String())
+	}
+}
+
+// ロックを持っている間は、別の接続（別のプロセス）の up / down は断られる。
+// ドライランは書き込まないので、ロック中でも実行できる
+func TestMigrateRefusedWhileLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.db")
+	first := openTestDB(t, path)
+	second := openTestDB(t, path)
+	if _, err := MigrateUp(first, MigrateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := lockMigrations(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var locked *MigrationLockedError
+	if _, err := MigrateDown(second, 1, MigrateOptions{}); !errors.As(err, &locked) || locked.Owner != lockOwner() {
+		t.Fatalf("ロック中の down: err = %v, want MigrationLockedError", err)
+	}
+	if _, err := MigrateUp(second, MigrateOptions{}); !errors.As(err, &locked) {
+		t.Fatalf("ロック中の up: err = %v, want MigrationLockedError", err)
+	}
+	if lock, err := MigrationLock(second); err != nil || lock == nil {
+		t.Fatalf("MigrationLock = %v, %v", lock, err)
+	}
+	if _, err := MigrateDown(second, 1, MigrateOptions{DryRun: true}); err != nil {
+		t.Fatalf("ロック中のドライラン: %v", err)
+	}
+	before := schemaVersion(t, second)
+
+	unlock()
+	if n, err := MigrateDown(second, 1, MigrateOptions{}); err != nil || n != 1 {
+		t.Fatalf("ロックが外れた後の down: %d, %v", n, err)
+	}
+	if v := schemaVersion(t, second); v != before-1 {
+		t.Fatalf("version = %d, want %d", v, before-1)
+	}
+
+	// 落ちたプロセスが残したロックは unlock で外せる
+	if _, err := lockMigrations(first); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := UnlockMigrations(second); err != nil || !removed {
+		t.Fatalf("UnlockMigrations = %v, %v", removed, err)
+	}
+	if _, err := MigrateUp(second, MigrateOptions{}); err != nil {
+		t.Fatalf("ロックを外した後の up: %v", err)
+	}
+}
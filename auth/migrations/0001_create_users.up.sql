@@ -0,0 +1,15 @@
+-- ユーザーとパスワード履歴
+CREATE TABLE users (
+    id                  INTEGER PRIMARY KEY AUTOINCREMENT,
+    username            TEXT     NOT NULL UNIQUE,
+    password_hash       BLOB     NOT NULL,
+    password_changed_at DATETIME NOT NULL
+);
+
+-- 過去のパスワードハッシュ（position が小さいほど新しい）
+CREATE TABLE password_history (
+    user_id       INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    position      INTEGER NOT NULL,
+    password_hash BLOB    NOT NULL,
+    PRIMARY KEY (user_id, position)
+);
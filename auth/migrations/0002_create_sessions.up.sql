@@ -0,0 +1,9 @@
+-- ログイン中のセッション（ユーザーを消したらセッションも消える）
+CREATE TABLE sessions (
+    id         TEXT     PRIMARY KEY,
+    user_id    INTEGER  NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL
+);
+
+CREATE INDEX sessions_user_id ON sessions(user_id);
@@ -0,0 +1,3 @@
+-- ユーザー名を入力されたままの名前に戻す（ぶつかるものは小文字のまま残す）
+UPDATE OR IGNORE users SET username = display_name WHERE display_name <> '';
+ALTER TABLE users DROP COLUMN display_name;
@@ -0,0 +1,7 @@
+-- username は正規化した名前（検索のキー）、display_name は入力されたままの名前（表示用）
+ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+UPDATE users SET display_name = username;
+
+-- 既存のユーザー名を小文字にそろえる（SQLite の lower() は ASCII のみ。全角などは OpenSQLite が Go で正規化し直す）。
+-- 小文字にすると他のユーザーとぶつかるものは、そのまま残す
+UPDATE OR IGNORE users SET username = lower(username);
@@ -0,0 +1,5 @@
+DROP INDEX one_time_tokens_user_id;
+DROP TABLE one_time_tokens;
+DROP INDEX users_email;
+ALTER TABLE users DROP COLUMN email_verified_at;
+ALTER TABLE users DROP COLUMN email;
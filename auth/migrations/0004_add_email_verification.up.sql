@@ -0,0 +1,16 @@
+-- メールアドレス（空なら未登録）と確認日時（NULLなら未確認）
+ALTER TABLE users ADD COLUMN email TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN email_verified_at DATETIME;
+
+-- 同じメールアドレスは1人だけ（未登録の '' は何人いてもよい）
+CREATE UNIQUE INDEX users_email ON users(email) WHERE email <> '';
+
+-- 使い捨てトークン（メール確認など）。保存するのはトークンの SHA-256 だけ
+CREATE TABLE one_time_tokens (
+    token_hash TEXT     PRIMARY KEY,
+    purpose    TEXT     NOT NULL,
+    user_id    INTEGER  NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at DATETIME NOT NULL
+);
+
+CREATE INDEX one_time_tokens_user_id ON one_time_tokens(user_id);
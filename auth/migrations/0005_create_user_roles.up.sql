@@ -0,0 +1,9 @@
+-- ユーザーのロール（1人に複数つけられる）
+CREATE TABLE user_roles (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role    TEXT    NOT NULL,
+    PRIMARY KEY (user_id, role)
+);
+
+-- 既存のユーザーには全員 user ロールをつける
+INSERT INTO user_roles (user_id, role) SELECT id, 'user' FROM users;
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN password_reset_required;
+ALTER TABLE users DROP COLUMN disabled_at;
@@ -0,0 +1,3 @@
+-- 管理者による無効化（NULLなら有効）とパスワードリセットの要求
+ALTER TABLE users ADD COLUMN disabled_at DATETIME;
+ALTER TABLE users ADD COLUMN password_reset_required INTEGER NOT NULL DEFAULT 0;
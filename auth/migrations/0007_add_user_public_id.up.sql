@@ -0,0 +1,5 @@
+-- 変わらないユーザーID（ULID）。内部の連番 id とは別に持つ。
+-- SQL では ULID を作れないので、既存のユーザーには起動時にアプリが割り当てる（'' は未割り当て）
+ALTER TABLE users ADD COLUMN public_id TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX users_public_id ON users(public_id) WHERE public_id <> '';
@@ -0,0 +1,6 @@
+DROP INDEX users_state;
+ALTER TABLE users ADD COLUMN disabled_at DATETIME;
+-- 退会済みも無効として戻す（確認待ちは email_verified_at から元どおりわかる）
+UPDATE users SET disabled_at = state_changed_at WHERE state IN ('suspended', 'deactivated');
+ALTER TABLE users DROP COLUMN state_changed_at;
+ALTER TABLE users DROP COLUMN state;
@@ -0,0 +1,9 @@
+-- 無効化（disabled_at）をアカウントの状態（pending / active / suspended / deactivated）に置き換える
+ALTER TABLE users ADD COLUMN state TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE users ADD COLUMN state_changed_at DATETIME;
+UPDATE users SET state = 'suspended', state_changed_at = disabled_at WHERE disabled_at IS NOT NULL;
+UPDATE users SET state = 'pending' WHERE state = 'active' AND email <> '' AND email_verified_at IS NULL;
+UPDATE users SET state_changed_at = password_changed_at WHERE state_changed_at IS NULL;
+ALTER TABLE users DROP COLUMN disabled_at;
+-- 削除ジョブが「退会から猶予期間が過ぎたユーザー」を探す
+CREATE INDEX users_state ON users (state, state_changed_at);
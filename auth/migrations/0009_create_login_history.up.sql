@@ -0,0 +1,10 @@
+-- ログイン履歴（ユーザーを消したら履歴も消える）
+CREATE TABLE login_history (
+    id           INTEGER  PRIMARY KEY,
+    user_id      INTEGER  NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    logged_in_at DATETIME NOT NULL,
+    ip           TEXT     NOT NULL,
+    user_agent   TEXT     NOT NULL
+);
+
+CREATE INDEX login_history_user_id ON login_history(user_id, logged_in_at);
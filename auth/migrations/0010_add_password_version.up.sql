@@ -0,0 +1,2 @@
+-- パスワードを設定し直すたびに1増える番号（JWT に入れ、変更前に発行されたものを弾く）
+ALTER TABLE users ADD COLUMN password_version INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- 起動時に BootstrapAdmin が作った初期管理者か（admin ロールを外されていたらつけ直してよいのは、このユーザーだけ）
+ALTER TABLE users ADD COLUMN bootstrap_admin INTEGER NOT NULL DEFAULT 0;
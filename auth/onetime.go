@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ===================
+// 使い捨てトークン
+// ===================
+
+// メール確認などのリンクに入れるトークン。
+//   - 保存するのは SHA-256 ハッシュだけ（DBが漏れても、リンクを作れない）
+//   - 1回使ったら消す（Consume は取り出しと削除を同時に行う）
+//   - 有効期限を過ぎたら使えない
+//
+// トークン自体は32バイトの乱数なので、パスワードと違って総当たりは現実的でない。
+// そのためソルトや bcrypt は不要で、SHA-256 で十分（検索のキーにもそのまま使える）
+
+var ErrTokenInvalid = errors.New("リンクが無効か、有効期限が切れています")
+
+// トークンの用途（メール確認用のトークンをパスワードリセットに使えないようにする）
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+)
+
+type OneTimeToken struct {
+	Hash      string // トークンの SHA-256（hex）
+	Purpose   string
+	UserID    string
+	ExpiresAt time.Time
+}
+
+// OneTimeTokenRepository は使い捨てトークンの保存先
+type OneTimeTokenRepository interface {
+	Create(token *OneTimeToken) error
+	Consume(hash, purpose string) (*OneTimeToken, error) // 取り出して消す。なければ ErrTokenInvalid
+	DeleteUser(userID, purpose string) error             // そのユーザーの未使用のトークンを全て消す
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// トークンを発行し、リンクに入れる生の値を返す
+func issueOneTimeToken(repo OneTimeTokenRepository, userID, purpose string, ttl time.Duration) (string, error) {
+	raw, err := generateSessionID() // セッションIDと同じく32バイトの乱数
+	if err != nil {
+		return "", err
+	}
+	err = repo.Create(&OneTimeToken{
+		Hash:      hashToken(raw),
+		Purpose:   purpose,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", fmt.Errorf("トークンの保存に失敗: %w", err)
+	}
+	return raw, nil
+}
+
+// トークンを使う（期限切れでも消える）
+func consumeOneTimeToken(repo OneTimeTokenRepository, raw, purpose string) (*OneTimeToken, error) {
+	if raw == "" {
+		return nil, ErrTokenInvalid
+	}
+	token, err := repo.Consume(hashToken(raw), purpose)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, ErrTokenInvalid
+	}
+	return token, nil
+}
+
+// --- インメモリの保存先 ---
+
+// 数が少なく頻繁にも使わないので、シャードに分けずに1つのロックで守る
+type MemoryOneTimeTokenRepository struct {
+	mu     sync.Mutex
+	tokens map[string]*OneTimeToken // key: ハッシュ
+}
+
+func NewMemoryOneTimeTokenRepository() *MemoryOneTimeTokenRepository {
+	return &MemoryOneTimeTokenRepository{tokens: make(map[string]*OneTimeToken)}
+}
+
+func (r *MemoryOneTimeTokenRepository) Create(token *OneTimeToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// 使われずに期限が切れたものは、ここで掃除する
+	now := time.Now()
+	for hash, t := range r.tokens {
+		if now.After(t.ExpiresAt) {
+			delete(r.tokens, hash)
+		}
+	}
+	c := *token
+	r.tokens[token.Hash] = &c
+	return nil
+}
+
+func (r *MemoryOneTimeTokenRepository) Consume(hash, purpose string) (*OneTimeToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token, exists := r.tokens[hash]
+	if !exists || token.Purpose != purpose {
+		return nil, ErrTokenInvalid
+	}
+	// 同じリンクを同時に2回開いても、成功するのは1回だけ
+	delete(r.tokens, hash)
+	return token, nil
+}
+
+func (r *MemoryOneTimeTokenRepository) DeleteUser(userID, purpose string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for hash, token := range r.tokens {
+		if token.UserID == userID && token.Purpose == purpose {
+			delete(r.tokens, hash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ===================
+// ペッパー（サーバー側の秘密鍵）
+// ===================
+
+// ソルトはハッシュと一緒にDBに保存されるが、ペッパーはDBの外（鍵ファイル）に置く。
+// ハッシュ化の前に HMAC(ペッパー, パスワード) をかけておけば、
+// ユーザーテーブルだけが漏洩しても総当たりができない。
+//
+//	$pepper$v=2$argon2id$v=19$m=19456,t=2,p=1$...
+//	        └─ 使ったペッパーのバージョン（鍵を入れ替えても検証できるように）
+
+// ペッパーの鍵1つ分
+type PepperKey struct {
+	Version int    `json:"version"`
+	Secret  []byte `json:"secret"`  // JSONではbase64で保存される
+	Retired bool   `json:"retired"` // true なら次回ログイン時に現在の鍵でかけ直す
+}
+
+// バージョン付きのペッパー鍵の束
+type PepperKeyring struct {
+	Current int          `json:"current"` // 新規ハッシュで使うバージョン
+	Keys    []*PepperKey `json:"keys"`
+}
+
+// 鍵ファイルを読み込む
+func LoadPepperKeyring(path string) (*PepperKeyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ring PepperKeyring
+	if err := json.Unmarshal(data, &ring); err != nil {
+		return nil, fmt.Errorf("ペッパー鍵ファイルのパースに失敗: %w", err)
+	}
+	if ring.Key(ring.Current) == nil {
+		return nil, fmt.Errorf("現在のペッパー鍵（v=%d）がありません", ring.Current)
+	}
+	return &ring, nil
+}
+
+// 鍵ファイルを保存する（所有者だけが読めるように 0600）
+func (r *PepperKeyring) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func (r *PepperKeyring) Key(version int) *PepperKey {
+	for _, k := range r.Keys {
+		if k.Version == version {
+			return k
+		}
+	}
+	return nil
+}
+
+// 新しい鍵を作って現在のバージョンにし、古い鍵は全て退役させる。
+// 退役した鍵も検証には使えるので、既存ユーザーは次回ログイン時にかけ直される
+func (r *PepperKeyring) Rotate() (*PepperKey, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	next := 1
+	for _, k := range r.Keys {
+		k.Retired = true
+		if k.Version >= next {
+			next = k.Version + 1
+		}
+	}
+
+	key := &PepperKey{Version: next, Secret: secret}
+	r.Keys = append(r.Keys, key)
+	sort.Slice(r.Keys, func(i, j int) bool { return r.Keys[i].Version < r.Keys[j].Version })
+	r.Current = key.Version
+	return key, nil
+}
+
+// 鍵ファイルを読み込んでローテーションし、保存する（ファイルがなければ新規作成）
+func RotatePepperKeyring(path string) (*PepperKey, error) {
+	ring, err := LoadPepperKeyring(path)
+	if os.IsNotExist(err) {
+		ring = &PepperKeyring{}
+	} else if err != nil {
+		return nil, err
+	}
+
+	key, err := ring.Rotate()
+	if err != nil {
+		return nil, err
+	}
+	if err := ring.Save(path); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// PepperedHasher はパスワードにペッパーをかけてから inner でハッシュ化する
+type PepperedHasher struct {
+	inner PasswordHasher
+	ring  *PepperKeyring
+}
+
+func NewPepperedHasher(inner PasswordHasher, ring *PepperKeyring) *PepperedHasher {
+	return &PepperedHasher{inner: inner, ring: ring}
+}
+
+// HMAC-SHA256(ペッパー, パスワード) を base64 にする（44文字なので bcrypt の72バイト制限に収まる）
+func applyPepper(key *PepperKey, password string) string {
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte(password))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (h *PepperedHasher) Hash(password string) ([]byte, error) {
+	key := h.ring.Key(h.ring.Current)
+	inner, err := h.inner.Hash(applyPepper(key, password))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("$pepper$v=%d%s", key.Version, inner)), nil
+}
+
+func (h *PepperedHasher) Verify(hash []byte, password string) error {
+	version, inner, err := parsePeppered(hash)
+	if err != nil {
+		return err
+	}
+	key := h.ring.Key(version)
+	if key == nil {
+		return fmt.Errorf("ペッパー鍵（v=%d）が見つかりません", version)
+	}
+	return h.inner.Verify(inner, applyPepper(key, password))
+}
+
+func (h *PepperedHasher) CanVerify(hash []byte) bool {
+	return strings.HasPrefix(string(hash), "$pepper$")
+}
+
+// 退役した鍵で作られたハッシュか、中身のハッシュが古ければ作り直す
+func (h *PepperedHasher) NeedsRehash(hash []byte) bool {
+	version, inner, err := parsePeppered(hash)
+	if err != nil {
+		return true
+	}
+	key := h.ring.Key(version)
+	if key == nil || key.Retired || version != h.ring.Current {
+		return true
+	}
+	return h.inner.NeedsRehash(inner)
+}
+
+// "$pepper$v=2$..." からバージョンと中身のハッシュを取り出す
+func parsePeppered(hash []byte) (int, []byte, error) {
+	// "" / "pepper" / "v=2" / 中身のハッシュ（先頭の$を除く）
+	parts := strings.SplitN(string(hash), "$", 4)
+	if len(parts) != 4 || parts[1] != "pepper" {
+		return 0, nil, fmt.Errorf("ペッパー付きハッシュの形式が不正です")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return 0, nil, fmt.Errorf("ペッパーのバージョンが不正です: %w", err)
+	}
+	return version, []byte("$" + parts[3]), nil
+}
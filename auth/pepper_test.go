@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ローテーションしても古い鍵のハッシュは検証でき、次回ログインでかけ直しになる
+func TestPepperRotationKeepsOldHashesVerifiable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pepper_keyring.json")
+	if _, err := RotatePepperKeyring(path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("鍵ファイルの権限 = %o, want 600", perm)
+	}
+
+	inner := NewBcryptHasher(bcrypt.MinCost, false)
+	ring, err := LoadPepperKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1Hash, err := NewPepperedHasher(inner, ring).Hash("secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(v1Hash, []byte("$pepper$v=1$2a$")) {
+		t.Fatalf("鍵のバージョンが記録されていない: %s", v1Hash)
+	}
+
+	if _, err := RotatePepperKeyring(path); err != nil {
+		t.Fatal(err)
+	}
+	if ring, err = LoadPepperKeyring(path); err != nil {
+		t.Fatal(err)
+	}
+	if ring.Current != 2 || !ring.Key(1).Retired || ring.Key(2).Retired {
+		t.Fatalf("ローテーション後の鍵: current=%d, v1退役=%v, v2退役=%v", ring.Current, ring.Key(1).Retired, ring.Key(2).Retired)
+	}
+	h := NewPepperedHasher(inner, ring)
+
+	if err := h.Verify(v1Hash, "secret-password"); err != nil {
+		t.Fatalf("退役した鍵のハッシュが検証できない: %v", err)
+	}
+	if err := h.Verify(v1Hash, "wrong-password"); !errors.Is(err, ErrPasswordMismatch) {
+		t.Fatalf("違うパスワードで err = %v, want ErrPasswordMismatch", err)
+	}
+	if !h.NeedsRehash(v1Hash) {
+		t.Error("退役した鍵のハッシュが作り直しにならない")
+	}
+	v2Hash, err := h.Hash("secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(v2Hash, []byte("$pepper$v=2$")) || h.NeedsRehash(v2Hash) {
+		t.Errorf("現在の鍵のハッシュ: %s（作り直し=%v）", v2Hash, h.NeedsRehash(v2Hash))
+	}
+}
+
+// 鍵ファイルにない鍵で作られたハッシュは、一致しないのではなくエラーにする（鍵をなくしたことに気づけるように）
+func TestPepperVerifyWithoutKey(t *testing.T) {
+	inner := NewBcryptHasher(bcrypt.MinCost, false)
+	ring := &PepperKeyring{}
+	if _, err := ring.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := NewPepperedHasher(inner, ring).Hash("secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := &PepperKeyring{}
+	if _, err := other.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	// 同じバージョンでも秘密が違えば一致しない（DBだけ漏れても総当たりできない）
+	if err := NewPepperedHasher(inner, other).Verify(hash, "secret-password"); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("別の鍵で err = %v, want ErrPasswordMismatch", err)
+	}
+	other.Keys[0].Version = 5
+	other.Current = 5
+	if err := NewPepperedHasher(inner, other).Verify(hash, "secret-password"); err == nil || errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("鍵がないのに err = %v", err)
+	}
+}
+
+// ペッパーを使い始めても、ペッパーなしのハッシュは検証でき、次回ログインでペッパー付きになる
+func TestHasherFromConfigAcceptsUnpepperedHashes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pepper_keyring.json")
+	if _, err := RotatePepperKeyring(path); err != nil {
+		t.Fatal(err)
+	}
+	cfg := HashConfig{Algorithm: "bcrypt", BcryptCost: bcrypt.MinCost, Argon2: testArgon2Params}
+	plain, err := NewHasherFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.PepperKeyring = path
+	peppered, err := NewHasherFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldHash, err := plain.Hash("secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := peppered.Verify(oldHash, "secret-password"); err != nil {
+		t.Fatalf("ペッパーなしのハッシュが検証できない: %v", err)
+	}
+	if !peppered.NeedsRehash(oldHash) {
+		t.Error("ペッパーなしのハッシュが作り直しにならない")
+	}
+	newHash, err := peppered.Hash("secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(newHash, []byte("$pepper$")) || peppered.NeedsRehash(newHash) {
+		t.Errorf("新しいハッシュ: %s", newHash)
+	}
+
+	// 現在の鍵がない鍵ファイルでは起動しない
+	if err := os.WriteFile(path, []byte(`{"current":3,"keys":[]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewHasherFromConfig(cfg); err == nil {
+		t.Error("現在の鍵がないのに起動できた")
+	}
+}
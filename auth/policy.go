@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ===================
+// パスワードポリシー
+// ===================
+
+// NIST SP 800-63B の考え方に沿ったチェック。
+// 「大文字・記号を必ず含める」のような文字種の強制はせず、
+// 長さ・推定エントロピー・よく使われるパスワード・ユーザー名との類似を見る。
+
+// ルール違反1件分（登録APIのレスポンスにそのまま入る）
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ポリシー違反のエラー。違反したルールを全て持つ
+type PasswordPolicyError struct {
+	Violations []PolicyViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "パスワードがポリシーを満たしていません"
+}
+
+// 漏洩パスワードの索引が読めず、パスワードを確かめられなかった。
+// 黙って通すとチェックを入れた意味がなくなるので、登録・変更は断る（503）
+var ErrBreachCheckUnavailable = errors.New("パスワードを確認できません。しばらくしてから再試行してください")
+
+func PolicyViolations(err error) ([]PolicyViolation, bool) {
+	var policyErr *PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		return policyErr.Violations, true
+	}
+	return nil, false
+}
+
+type PasswordPolicy struct {
+	MinLength      int
+	MaxLength      int
+	MinEntropyBits float64
+	HistorySize    int             // パスワード変更時に再利用を禁止する過去のハッシュの数
+	MinAge         time.Duration   // 前回の変更から次に変更できるまでの最短期間
+	common         map[string]bool // よく使われるパスワード（小文字）
+	breach         *BreachChecker  // 漏洩パスワードの索引（nilならチェックしない）
+}
+
+// よく使われるパスワードの組み込みのリスト（policy.common_passwords が空のとき使う）
+//
+//go:embed common_passwords.txt
+var defaultCommonPasswords string
+
+// 設定からポリシーを作る。よく使われるパスワードのリストは、パスを指定すればそのファイル、空なら組み込みのものを使う
+func NewPasswordPolicy(cfg PolicyConfig) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{
+		MinLength:      cfg.MinLength,
+		MaxLength:      cfg.MaxLength,
+		MinEntropyBits: cfg.MinEntropyBits,
+		HistorySize:    cfg.HistorySize,
+		MinAge:         time.Duration(cfg.MinAgeMinutes) * time.Minute,
+		common:         make(map[string]bool),
+	}
+	if cfg.BreachIndex != "" {
+		// バケットがないプレフィックスは「漏洩なし」と読むので、索引ごとないと全てのパスワードが通ってしまう
+		if info, err := os.Stat(cfg.BreachIndex); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("漏洩パスワードの索引ディレクトリがありません（-build-breach-index で作ってください）: %s", cfg.BreachIndex)
+		}
+		p.breach = NewBreachChecker(cfg.BreachIndex, cfg.BreachThreshold)
+	}
+	if cfg.CommonPasswords == "" {
+		return p, p.loadCommonPasswords(strings.NewReader(defaultCommonPasswords))
+	}
+
+	// 指定したファイルがないのに黙ってチェックを飛ばすと、弱いパスワードが通ってしまう
+	f, err := os.Open(cfg.CommonPasswords)
+	if err != nil {
+		return nil, fmt.Errorf("よく使われるパスワードのリストを開けません: %w", err)
+	}
+	defer f.Close()
+	return p, p.loadCommonPasswords(f)
+}
+
+// 1行1つ。空行と # で始まる行は読み飛ばす
+func (p *PasswordPolicy) loadCommonPasswords(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		w := strings.TrimSpace(scanner.Text())
+		if w != "" && !strings.HasPrefix(w, "#") {
+			p.common[strings.ToLower(w)] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// パスワードをチェックし、違反があれば PasswordPolicyError を返す。
+// 漏洩パスワードの索引が読めなければ ErrBreachCheckUnavailable（違反がなかったことにはしない）
+func (p *PasswordPolicy) Validate(username, password string) error {
+	var violations []PolicyViolation
+	length := utf8.RuneCountInString(password)
+
+	if length < p.MinLength {
+		violations = append(violations, PolicyViolation{
+			Rule:    "min_length",
+			Message: fmt.Sprintf("%d文字以上にしてください", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, PolicyViolation{
+			Rule:    "max_length",
+			Message: fmt.Sprintf("%d文字以内にしてください", p.MaxLength),
+		})
+	}
+	if p.common[strings.ToLower(password)] {
+		violations = append(violations, PolicyViolation{
+			Rule:    "common_password",
+			Message: "よく使われているパスワードです",
+		})
+	}
+	if p.breach != nil {
+		breached, count, err := p.breach.IsBreached(password)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrBreachCheckUnavailable, err)
+		}
+		if breached {
+			violations = append(violations, PolicyViolation{
+				Rule:    "breached_password",
+				Message: fmt.Sprintf("過去の漏洩データに %d 件含まれているパスワードです", count),
+			})
+		}
+	}
+	if similarToUsername(username, password) {
+		violations = append(violations, PolicyViolation{
+			Rule:    "similar_to_username",
+			Message: "ユーザー名と似ています",
+		})
+	}
+	if bits := estimateEntropy(password); bits < p.MinEntropyBits {
+		violations = append(violations, PolicyViolation{
+			Rule:    "min_entropy",
+			Message: fmt.Sprintf("推測されやすいパスワードです（推定 %.1f ビット / 必要 %.1f ビット）", bits, p.MinEntropyBits),
+		})
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// 推定エントロピー（ビット）= 実質的な長さ × log2(使っている文字種の数)
+// 同じ文字の繰り返しや abc / 321 のような連続は、長さに数えない。
+// あくまで目安（辞書単語の組み合わせなどは見抜けない）
+func estimateEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	effective := 0
+	var prev rune = -1
+
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+		if prev < 0 || (r != prev && r != prev+1 && r != prev-1) {
+			effective++
+		}
+		prev = r
+	}
+
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		pool += 100 // 日本語など。実際はもっと多いが控えめに見積もる
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(effective) * math.Log2(float64(pool))
+}
+
+// パスワードがユーザー名を含む・ユーザー名に含まれる・逆順・ほぼ同じ（編集距離2以下）なら似ているとみなす
+func similarToUsername(username, password string) bool {
+	u := strings.ToLower(username)
+	p := strings.ToLower(password)
+	if utf8.RuneCountInString(u) < 3 || p == "" {
+		return false
+	}
+
+	if strings.Contains(p, u) || strings.Contains(u, p) || strings.Contains(p, reverse(u)) {
+		return true
+	}
+	return levenshtein(u, p) <= 2
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+// 編集距離（1文字の挿入・削除・置換を何回すれば同じになるか）
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// 違反したルールを全て返す（1つ目で止めない）
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy, err := NewPasswordPolicy(PolicyConfig{MinLength: 8, MaxLength: 32, MinEntropyBits: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name, username, password string
+		rules                    []string
+	}{
+		{"十分に強い", "taro", "quiet-lantern-orchard-42", nil},
+		{"短い", "taro", "k9$L", []string{"min_length", "min_entropy"}},
+		{"長すぎる", "taro", "quiet-lantern-orchard-42-quiet-lantern", []string{"max_length"}},
+		{"よく使われる（大文字小文字は区別しない）", "taro", "PASSWORD123", []string{"common_password"}},
+		{"ユーザー名を含む", "hanako", "my-hanako-garden-7", []string{"similar_to_username"}},
+		{"ユーザー名の逆順を含む", "hanako", "okanah-river-bank-3", []string{"similar_to_username"}},
+		{"繰り返しと連続は長さに数えない", "taro", "aaaaaaaaaaaabcdefgh", []string{"min_entropy"}},
+		{"日本語のパスフレーズ", "taro", "しずかな灯りの果樹園", nil},
+	} {
+		err := policy.Validate(tc.username, tc.password)
+		violations, _ := PolicyViolations(err)
+		var rules []string
+		for _, v := range violations {
+			rules = append(rules, v.Rule)
+		}
+		if !slices.Equal(rules, tc.rules) {
+			t.Errorf("%s: rules = %v, want %v", tc.name, rules, tc.rules)
+		}
+	}
+}
+
+func TestEstimateEntropy(t *testing.T) {
+	for _, tc := range []struct {
+		password string
+		min, max float64
+	}{
+		{"", 0, 0},
+		{"aaaaaaaa", 4.7, 4.71}, // 繰り返しは1文字分（log2(26)）
+		{"abcdefgh", 4.7, 4.71}, // 連続も1文字分
+		{"zq", 9.4, 9.41},       // 2 × log2(26)
+		{"Zq7!", 26.2, 26.3},    // 4 × log2(95)
+		{"あい", 13.28, 13.29},    // 日本語は控えめに100種類
+	} {
+		if got := estimateEntropy(tc.password); got < tc.min || got > tc.max {
+			t.Errorf("estimateEntropy(%q) = %.2f, want %.2f〜%.2f", tc.password, got, tc.min, tc.max)
+		}
+	}
+}
+
+func TestSimilarToUsername(t *testing.T) {
+	for _, tc := range []struct {
+		username, password string
+		want               bool
+	}{
+		{"taro", "Taro2025", true},
+		{"taro", "orat", true},
+		{"taro", "tar0", true}, // 編集距離1
+		{"taro", "quiet-lantern-orchard", false},
+		{"ab", "ab-garden-path", false}, // 短いユーザー名は見ない
+	} {
+		if got := similarToUsername(tc.username, tc.password); got != tc.want {
+			t.Errorf("similarToUsername(%q, %q) = %v, want %v", tc.username, tc.password, got, tc.want)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"kitten", "sitting", 3},
+		{"太郎", "次郎", 1}, // 文字単位で数える（バイト単位ではない）
+		{"abc", "", 3},
+	} {
+		if got := levenshtein(tc.a, tc.b); got != tc.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+// 指定したリストを使い、ファイルがなければ起動しない（黙ってチェックを飛ばさない）
+func TestNewPasswordPolicyCommonPasswordsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "common.txt")
+	if err := os.WriteFile(path, []byte("# 社内でよく使われる\nCorpName2025!\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := NewPasswordPolicy(PolicyConfig{MinLength: 8, CommonPasswords: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if violations, _ := PolicyViolations(policy.Validate("taro", "corpname2025!")); len(violations) != 1 || violations[0].Rule != "common_password" {
+		t.Errorf("リストのパスワードが通った: %v", violations)
+	}
+	if err := policy.Validate("taro", "password123"); err != nil {
+		t.Errorf("組み込みのリストではなく指定したリストを使うはず: %v", err)
+	}
+
+	if _, err := NewPasswordPolicy(PolicyConfig{CommonPasswords: filepath.Join(t.TempDir(), "missing.txt")}); err == nil {
+		t.Error("リストがないのに作れた")
+	}
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===================
+// ハッシュ計算のワーカープール
+// ===================
+
+// bcrypt / Argon2id はわざと重い処理なので、ログインが集中すると全CPUを使い切り、
+// 他のリクエストまで巻き込んで遅くなる。
+// 同時に計算できる数を制限し、一定時間待っても順番が来なければ 503 で断る（過負荷の切り捨て）。
+
+// プールが混んでいて順番が来なかったときのエラー
+type HashPoolBusyError struct {
+	RetryAfter time.Duration
+}
+
+func (e *HashPoolBusyError) Error() string {
+	return "ハッシュ計算が混み合っています"
+}
+
+// Retry-After ヘッダーに入れる秒数（切り上げ、最低1秒）
+func (e *HashPoolBusyError) RetryAfterSeconds() int {
+	return max(1, int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+func IsHashPoolBusy(err error) bool {
+	var busy *HashPoolBusyError
+	return errors.As(err, &busy)
+}
+
+type HashPool struct {
+	slots        chan struct{} // 空きスロット（バッファの大きさ = 同時実行数）
+	queueTimeout time.Duration // 順番待ちの上限
+
+	queued    atomic.Int64 // 順番待ちの数
+	running   atomic.Int64 // 計算中の数
+	completed atomic.Int64
+	rejected  atomic.Int64
+
+	mu        sync.Mutex
+	waitCount int64
+	totalWait time.Duration
+	maxWait   time.Duration
+}
+
+func NewHashPool(concurrency int, queueTimeout time.Duration) *HashPool {
+	return &HashPool{
+		slots:        make(chan struct{}, concurrency),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// fn をプールの中で実行する。queueTimeout 以内に順番が来なければ HashPoolBusyError を返す
+func (p *HashPool) Do(fn func() error) error {
+	start := time.Now()
+
+	select {
+	case p.slots <- struct{}{}:
+		// 空きがあればすぐ実行
+	default:
+		p.queued.Add(1)
+		timer := time.NewTimer(p.queueTimeout)
+		select {
+		case p.slots <- struct{}{}:
+			timer.Stop()
+			p.queued.Add(-1)
+		case <-timer.C:
+			p.queued.Add(-1)
+			p.rejected.Add(1)
+			return &HashPoolBusyError{RetryAfter: p.queueTimeout}
+		}
+	}
+	p.recordWait(time.Since(start))
+
+	p.running.Add(1)
+	defer func() {
+		<-p.slots
+		p.running.Add(-1)
+		p.completed.Add(1)
+	}()
+	return fn()
+}
+
+func (p *HashPool) recordWait(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.waitCount++
+	p.totalWait += d
+	p.maxWait = max(p.maxWait, d)
+}
+
+// プールの状態
+type HashPoolStats struct {
+	Concurrency int     `json:"concurrency"`
+	Running     int64   `json:"running"`
+	Queued      int64   `json:"queued"` // 順番待ちの数（キューの深さ）
+	Completed   int64   `json:"completed"`
+	Rejected    int64   `json:"rejected"` // 待ちきれずに 503 を返した数
+	AvgWaitMs   float64 `json:"avg_wait_ms"`
+	MaxWaitMs   float64 `json:"max_wait_ms"`
+}
+
+func (p *HashPool) Stats() HashPoolStats {
+	p.mu.Lock()
+	var avg time.Duration
+	if p.waitCount > 0 {
+		avg = p.totalWait / time.Duration(p.waitCount)
+	}
+	maxWait := p.maxWait
+	p.mu.Unlock()
+
+	return HashPoolStats{
+		Concurrency: cap(p.slots),
+		Running:     p.running.Load(),
+		Queued:      p.queued.Load(),
+		Completed:   p.completed.Load(),
+		Rejected:    p.rejected.Load(),
+		AvgWaitMs:   float64(avg) / float64(time.Millisecond),
+		MaxWaitMs:   float64(maxWait) / float64(time.Millisecond),
+	}
+}
+
+// GET /stats/hashpool
+func (p *HashPool) HandleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.Stats())
+}
+
+// PooledHasher はハッシュ化と検証をプールの中で行う
+type PooledHasher struct {
+	inner PasswordHasher
+	pool  *HashPool
+}
+
+func NewPooledHasher(inner PasswordHasher, pool *HashPool) *PooledHasher {
+	return &PooledHasher{inner: inner, pool: pool}
+}
+
+func (h *PooledHasher) Hash(password string) ([]byte, error) {
+	var hash []byte
+	err := h.pool.Do(func() error {
+		var err error
+		hash, err = h.inner.Hash(password)
+		return err
+	})
+	return hash, err
+}
+
+func (h *PooledHasher) Verify(hash []byte, password string) error {
+	return h.pool.Do(func() error {
+		return h.inner.Verify(hash, password)
+	})
+}
+
+// 形式のチェックは軽いのでプールを通さない
+func (h *PooledHasher) CanVerify(hash []byte) bool {
+	return h.inner.CanVerify(hash)
+}
+
+func (h *PooledHasher) NeedsRehash(hash []byte) bool {
+	return h.inner.NeedsRehash(hash)
+}
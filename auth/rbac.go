@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ===================
+// ロールと権限
+// ===================
+
+// ユーザーにはロール（user / admin など）をつけ、ロールごとに権限（profile:read など）を決める。
+// ルートごとに必要な権限を Require で指定すると、ログインしていなければ 401、権限がなければ 403 を返す。
+//
+//	http.HandleFunc("/profile", api.Require(auth.PermProfileRead, api.HandleProfile))
+//
+// ログイン中のユーザーは CurrentUser で調べるので、セッションCookie でも JWT でも同じように動く。
+
+type Permission string
+
+const (
+	PermProfileRead    Permission = "profile:read"    // 自分のプロフィールを見る
+	PermPasswordChange Permission = "password:change" // 自分のパスワードを変える
+	PermStatsRead      Permission = "stats:read"      // ハッシュ計算のワーカープールの状態を見る
+	PermLockoutsRead   Permission = "lockouts:read"   // ログイン試行の制限・ロック中のアカウントを見る
+	PermUsersRead      Permission = "users:read"      // ユーザーの一覧・詳細を見る
+	PermUsersWrite     Permission = "users:write"     // ユーザーを停止・有効化・退会・削除し、パスワードのリセットを求める
+	PermAccountExport  Permission = "account:export"  // 自分の個人データをダウンロードする
+	PermAccountDelete  Permission = "account:delete"  // 自分のアカウントを削除する
+)
+
+const (
+	RoleUser  = "user"  // 登録したユーザーに最初からつくロール
+	RoleAdmin = "admin" // 管理者
+)
+
+// ロール → 権限
+type Roles map[string][]Permission
+
+func DefaultRoles() Roles {
+	user := []Permission{PermProfileRead, PermPasswordChange, PermAccountExport, PermAccountDelete}
+	return Roles{
+		RoleUser:  user,
+		RoleAdmin: append(slices.Clone(user), PermStatsRead, PermLockoutsRead, PermUsersRead, PermUsersWrite),
+	}
+}
+
+// ユーザーのどれかのロールがその権限を持っているか（知らないロールは何の権限も持たない）
+func (r Roles) Allows(user *User, perm Permission) bool {
+	for _, role := range user.Roles {
+		if slices.Contains(r[role], perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// ユーザーが持っている権限の一覧（重複なし）
+func (r Roles) Permissions(user *User) []Permission {
+	perms := []Permission{}
+	for _, role := range user.Roles {
+		for _, p := range r[role] {
+			if !slices.Contains(perms, p) {
+				perms = append(perms, p)
+			}
+		}
+	}
+	return perms
+}
+
+// --- ミドルウェア ---
+
+type userContextKey struct{}
+
+// Require で確かめたユーザーを取り出す（Require を通っていなければ nil）
+func UserFromContext(ctx context.Context) *User {
+	user, _ := ctx.Value(userContextKey{}).(*User)
+	return user
+}
+
+// ログインしていて、perm の権限を持つユーザーだけを next に通す
+func (a *API) Require(perm Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := a.CurrentUser(r)
+		if err != nil {
+			JSONResponse(w, http.StatusUnauthorized, Response{false, err.Error()})
+			return
+		}
+		if !a.roles().Allows(user, perm) {
+			log.Printf("権限がありません: %s に %s が必要", user.Username, perm)
+			JSONResponse(w, http.StatusForbidden, Response{false, "この操作をする権限がありません"})
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	}
+}
+
+func (a *API) roles() Roles {
+	if a.Roles == nil {
+		return DefaultRoles()
+	}
+	return a.Roles
+}
+
+// サーバーと同じマシンからのリクエストだけを通す（ログイン状態を持たないサーバーの管理用ルート向け）
+func LocalOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			JSONResponse(w, http.StatusForbidden, Response{false, "localhost からのみアクセスできます"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// --- 最初の管理者 ---
+
+// 管理者がいないと、ロールを変える人がいない。
+// 起動時に admin.username のユーザーがいなければ、admin ロールをつけて作る。
+// パスワードは環境変数 admin.password_env から読み、なければランダムに作って一度だけ表示する。
+//
+// もういれば admin ロールを持っているか確かめ、外されていたらつけ直す。
+// ただし、つけ直すのはこの関数が作ったユーザーだけ。誰かが先に同じ名前で登録していた場合に
+// 管理者にしてしまわないよう、エラーにして起動を止める
+func BootstrapAdmin(users *UserStore, cfg AdminConfig) error {
+	if cfg.Username == "" {
+		return nil
+	}
+	existing, err := users.Get(cfg.Username)
+	if err == nil {
+		return ensureBootstrapAdmin(users, existing)
+	}
+	if !errors.Is(err, ErrUserNotFound) {
+		return err
+	}
+
+	password := os.Getenv(cfg.PasswordEnv)
+	generated := password == ""
+	if generated {
+		b := make([]byte, 18)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		password = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	// ロールも一緒に保存する（作った後に落ちても、admin ロールのないユーザーが残らない）
+	err = users.register(cfg.Username, "", password, func(user *User) {
+		user.Roles = []string{RoleUser, RoleAdmin}
+		user.BootstrapAdmin = true
+	})
+	if errors.Is(err, ErrUserExists) {
+		// 同時に起動した別のサーバーが先に作った
+		existing, err := users.Get(cfg.Username)
+		if err != nil {
+			return err
+		}
+		return ensureBootstrapAdmin(users, existing)
+	}
+	if violations, ok := PolicyViolations(err); ok {
+		msgs := make([]string, len(violations))
+		for i, v := range violations {
+			msgs[i] = v.Message
+		}
+		return fmt.Errorf("初期管理者 '%s' を作れません（環境変数 %s のパスワード）: %s", cfg.Username, cfg.PasswordEnv, strings.Join(msgs, " / "))
+	}
+	if err != nil {
+		return fmt.Errorf("初期管理者 '%s' を作れません: %w", cfg.Username, err)
+	}
+
+	if generated {
+		log.Printf("初期管理者 '%s' を作りました。パスワード: %s（この表示は一度だけ。ログインしたら変更してください）", cfg.Username, password)
+	} else {
+		log.Printf("初期管理者 '%s' を作りました（パスワードは環境変数 %s）", cfg.Username, cfg.PasswordEnv)
+	}
+	return nil
+}
+
+// 既にいる初期管理者の admin ロールを確かめる。
+// admin ロールを持っていれば何もしない（0011 より前に作った初期管理者も、ロールがあればそのまま使える）
+func ensureBootstrapAdmin(users *UserStore, user *User) error {
+	if slices.Contains(user.Roles, RoleAdmin) {
+		return nil
+	}
+	if !user.BootstrapAdmin {
+		return fmt.Errorf("初期管理者 '%s' は起動時に作ったユーザーではなく、admin ロールもありません"+
+			"（別の人が同じ名前で登録した可能性があります。admin.username を変えるか、このユーザーを確かめてください）", user.Username)
+	}
+	if err := users.SetRoles(user.ID, append(slices.Clone(user.Roles), RoleAdmin)); err != nil {
+		return err
+	}
+	log.Printf("初期管理者 '%s' に admin ロールがなかったので、つけ直しました", user.Username)
+	return nil
+}
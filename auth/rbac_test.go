@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 初期管理者は admin ロールと一緒に作る。ロールを外されても次の起動でつけ直すが、
+// 先に同じ名前で登録された一般ユーザーは管理者にせず、エラーにする
+func TestBootstrapAdmin(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b *Backend) {
+		users, err := NewUserStore(b.Users, NewBcryptHasher(bcrypt.MinCost, false), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("TEST_ADMIN_PASSWORD", "quiet-lantern-orchard-42")
+		cfg := AdminConfig{Username: "admin", PasswordEnv: "TEST_ADMIN_PASSWORD"}
+		isAdmin := func() bool {
+			t.Helper()
+			user, err := users.Get("admin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return user.BootstrapAdmin && slices.Contains(user.Roles, RoleAdmin)
+		}
+
+		if err := BootstrapAdmin(users, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if !isAdmin() {
+			t.Fatal("作った初期管理者に admin ロールがない")
+		}
+		if _, err := users.Authenticate("admin", "quiet-lantern-orchard-42"); err != nil {
+			t.Fatalf("環境変数のパスワードでログインできない: %v", err)
+		}
+
+		admin, _ := users.Get("admin")
+		if err := users.SetRoles(admin.ID, []string{RoleUser}); err != nil {
+			t.Fatal(err)
+		}
+		if err := BootstrapAdmin(users, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if !isAdmin() {
+			t.Fatal("外された admin ロールをつけ直さない")
+		}
+
+		// 起動時に作ったのではない、同じ名前の一般ユーザー
+		if err := users.Register("root", "", "password-root"); err != nil {
+			t.Fatal(err)
+		}
+		err = BootstrapAdmin(users, AdminConfig{Username: "Root", PasswordEnv: "TEST_ADMIN_PASSWORD"})
+		if err == nil || !strings.Contains(err.Error(), "起動時に作ったユーザーではなく") {
+			t.Fatalf("err = %v, want 起動時に作ったユーザーではないエラー", err)
+		}
+		if root, _ := users.Get("root"); slices.Contains(root.Roles, RoleAdmin) {
+			t.Fatal("一般ユーザーを管理者にした")
+		}
+	})
+}
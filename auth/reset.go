@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ===================
+// パスワードリセット
+// ===================
+
+// パスワードを忘れたら、登録したメールアドレスにリセットリンクを送る。
+//
+//	/password/forgot（メールアドレス）→ リセットメール（/password/reset?token=...）→ /password/reset（新しいパスワード）
+//
+// トークンは確認メールと同じ使い捨てトークン（ハッシュで保存・期限付き・1回きり）。
+// 新しくリセットを頼むと、前のリンクは使えなくなる。
+// リセットしたら、そのユーザーのセッションを全て消す（盗まれたセッションも使えなくなる）。
+
+type PasswordResetter struct {
+	users    *UserStore
+	tokens   OneTimeTokenRepository
+	sessions SessionRepository // nilならセッションを消さない（セッションを持たないサーバー）
+	mailer   Mailer
+	baseURL  string
+	ttl      time.Duration // リンクの有効期限（確認メールより短くする）
+}
+
+func NewPasswordResetter(users *UserStore, tokens OneTimeTokenRepository, sessions SessionRepository, mailer Mailer, baseURL string, ttl time.Duration) *PasswordResetter {
+	return &PasswordResetter{
+		users:    users,
+		tokens:   tokens,
+		sessions: sessions,
+		mailer:   mailer,
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		ttl:      ttl,
+	}
+}
+
+// リセットリンクを送る。登録されていないアドレスでも何も言わない
+// （返事の違いから、そのアドレスが登録されているかを調べられないように）
+func (p *PasswordResetter) Forgot(email string) error {
+	user, err := p.users.GetByEmail(email)
+	if err != nil {
+		return nil
+	}
+
+	// 前に送ったリンクは使えなくする（最後に届いたメールだけが有効）
+	if err := p.tokens.DeleteUser(user.ID, TokenPurposeResetPassword); err != nil {
+		return err
+	}
+	raw, err := issueOneTimeToken(p.tokens, user.ID, TokenPurposeResetPassword, p.ttl)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(`%s さん
+
+パスワードのリセットを受け付けました。
+次のリンクを開いて、新しいパスワードを設定してください（有効期限: %v）。
+
+%s/password/reset?token=%s
+
+心当たりがない場合は、このメールを無視してください（パスワードは変わりません）。
+`, user.DisplayName, p.ttl, p.baseURL, raw)
+
+	if err := p.mailer.Send(Message{To: user.Email, Subject: "パスワードのリセット", Body: body}); err != nil {
+		return fmt.Errorf("リセットメールの送信に失敗: %w", err)
+	}
+	log.Printf("リセットメールを送信: %s", user.Username)
+	return nil
+}
+
+// リンクのトークンを使ってパスワードを設定し直し、そのユーザーのセッションを全て消す
+func (p *PasswordResetter) Reset(raw, newPassword string) error {
+	token, err := consumeOneTimeToken(p.tokens, raw, TokenPurposeResetPassword)
+	if err != nil {
+		return err
+	}
+
+	if err := p.users.ResetPassword(token.UserID, newPassword); err != nil {
+		// パスワードが変わっていなければ、同じリンクでやり直せるようにトークンを戻す
+		// （ポリシー違反だけでなく、混んでいた・保存に失敗したなどの一時的な失敗でもリンクを無駄にしない）。
+		// 同じリンクを同時に2回使われないよう、取り出しは先に済ませておく
+		if !errors.Is(err, ErrUserNotFound) {
+			if restoreErr := p.tokens.Create(token); restoreErr != nil {
+				log.Printf("リセットのトークンを戻せません: %s: %v", token.UserID, restoreErr)
+			}
+		}
+		return err
+	}
+
+	if p.sessions != nil {
+		n, err := p.sessions.DeleteUser(token.UserID)
+		if err != nil {
+			// パスワードは変わっているので、失敗は記録だけして成功を返す
+			log.Printf("セッションの削除に失敗: %s: %v", token.UserID, err)
+		} else {
+			log.Printf("パスワードリセットでセッションを %d 件削除: %s", n, token.UserID)
+		}
+	}
+	return nil
+}
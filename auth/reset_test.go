@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// busy が立っている間だけ、ハッシュ化をプールが混んでいたときと同じエラーで断る
+type busyHasher struct {
+	PasswordHasher
+	busy atomic.Bool
+}
+
+func (h *busyHasher) Hash(password string) ([]byte, error) {
+	if h.busy.Load() {
+		return nil, &HashPoolBusyError{}
+	}
+	return h.PasswordHasher.Hash(password)
+}
+
+// 混んでいてリセットできなかったときは、同じリンクでやり直せる
+func TestResetKeepsTokenOnTransientFailure(t *testing.T) {
+	hasher := &busyHasher{PasswordHasher: NewBcryptHasher(bcrypt.MinCost, false)}
+	users := newTestStore(t, hasher)
+	if err := users.Register("taro", "taro@example.com", "old-password"); err != nil {
+		t.Fatal(err)
+	}
+	user, err := users.Get("taro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := NewMemoryOneTimeTokenRepository()
+	resetter := NewPasswordResetter(users, tokens, nil, nil, "", 0)
+	raw, err := issueOneTimeToken(tokens, user.ID, TokenPurposeResetPassword, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hasher.busy.Store(true)
+	if err := resetter.Reset(raw, "new-password"); !IsHashPoolBusy(err) {
+		t.Fatalf("err = %v, want HashPoolBusyError", err)
+	}
+	hasher.busy.Store(false)
+	if err := resetter.Reset(raw, "new-password"); err != nil {
+		t.Fatalf("同じリンクでやり直せない: %v", err)
+	}
+	if _, err := users.Authenticate("taro", "new-password"); err != nil {
+		t.Fatalf("新しいパスワードでログインできない: %v", err)
+	}
+
+	// 成功したらリンクは使えなくなる
+	if err := resetter.Reset(raw, "another-password"); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("使ったリンクで err = %v, want ErrTokenInvalid", err)
+	}
+}
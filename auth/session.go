@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ===================
+// セッション管理
+// ===================
+
+type Session struct {
+	ID        string
+	UserID    string // ユーザー名ではなく ID（名前を変えても切れず、同じ名前の別人にも使えない）
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// SessionRepository はセッションの保存先
+type SessionRepository interface {
+	Create(userID string) (*Session, error)
+	Get(id string) (*Session, error) // 見つからない・期限切れならエラー
+	Delete(id string)
+	DeleteUser(userID string) (int, error)      // そのユーザーのセッションを全て消し、消した数を返す
+	ListUser(userID string) ([]*Session, error) // そのユーザーの期限内のセッション（作った順）
+}
+
+// セッションの有効期限
+const SessionTTL = 24 * time.Hour
+
+// インメモリのセッションストア（セッションIDのハッシュでシャードに分ける）
+type SessionStore struct {
+	shards [shardCount]sessionShard
+}
+
+type sessionShard struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session // key: セッションID
+}
+
+func NewSessionStore() *SessionStore {
+	s := &SessionStore{}
+	for i := range s.shards {
+		s.shards[i].sessions = make(map[string]*Session)
+	}
+	return s
+}
+
+func (s *SessionStore) shard(id string) *sessionShard {
+	return &s.shards[shardIndex(id)]
+}
+
+// ログ出力用
+func (s *SessionStore) String() string {
+	var lines []string
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		for id, session := range sh.sessions {
+			lines = append(lines, fmt.Sprintf("  - ID: %s... / User: %s\n", id[:16], session.UserID))
+		}
+		sh.mu.RUnlock()
+	}
+	if len(lines) == 0 {
+		return "セッションなし"
+	}
+	return fmt.Sprintf("セッション数: %d\n", len(lines)) + strings.Join(lines, "")
+}
+
+// セッションIDを生成（32バイトのランダムな文字列）
+func generateSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// 新しいセッションを作成
+func (s *SessionStore) Create(userID string) (*Session, error) {
+	id, err := generateSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &Session{
+		ID:        id,
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(SessionTTL),
+	}
+	sh := s.shard(id)
+	sh.mu.Lock()
+	sh.sessions[id] = session
+	sh.mu.Unlock()
+	return session, nil
+}
+
+// セッションIDからセッションを取得
+func (s *SessionStore) Get(id string) (*Session, error) {
+	sh := s.shard(id)
+	sh.mu.RLock()
+	session, exists := sh.sessions[id]
+	sh.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("セッションが見つかりません")
+	}
+	// 有効期限チェック
+	if time.Now().After(session.ExpiresAt) {
+		s.Delete(id)
+		return nil, fmt.Errorf("セッションが期限切れです")
+	}
+	return session, nil
+}
+
+// セッションを削除
+func (s *SessionStore) Delete(id string) {
+	sh := s.shard(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	delete(sh.sessions, id)
+}
+
+// ユーザーのセッションを全て削除（パスワードリセット時など）。
+// シャードはセッションIDで分けているので、全シャードを見て回る
+func (s *SessionStore) DeleteUser(userID string) (int, error) {
+	n := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for id, session := range sh.sessions {
+			if session.UserID == userID {
+				delete(sh.sessions, id)
+				n++
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return n, nil
+}
+
+// ユーザーのセッションの一覧（個人データのエクスポート用）。DeleteUser と同じく全シャードを見て回る
+func (s *SessionStore) ListUser(userID string) ([]*Session, error) {
+	now := time.Now()
+	var sessions []*Session
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		for _, session := range sh.sessions {
+			if session.UserID == userID && now.Before(session.ExpiresAt) {
+				c := *session
+				sessions = append(sessions, &c)
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	slices.SortFunc(sessions, func(a, b *Session) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return sessions, nil
+}
+
+// 保存されているセッション数（期限切れを含む）
+func (s *SessionStore) Len() int {
+	n := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		n += len(sh.sessions)
+		sh.mu.RUnlock()
+	}
+	return n
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// ===================
+// シャーディング
+// ===================
+
+// net/http はリクエストごとに goroutine を作るので、ストアは同時に読み書きされる。
+// map 全体を1つのロックで守ると全リクエストがそこに並ぶため、キーのハッシュで
+// shardCount 個に分け、別のシャードに入るキー同士は並行に読み書きできるようにする。
+
+const shardCount = 32
+
+func shardIndex(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % shardCount)
+}
+
+// キーごとのロック（同じキーへの「読んで → 書き戻す」を直列にする）。
+// キーの数だけ Mutex を作らず、シャード単位でロックする（別キーが同じロックを共有することはある）。
+// 共有しているので、持っている間にパスワードのハッシュ計算のような遅い処理をしない
+type keyedMutex struct {
+	locks [shardCount]sync.Mutex
+}
+
+func (m *keyedMutex) Lock(key string) func() {
+	mu := &m.locks[shardIndex(key)]
+	mu.Lock()
+	return mu.Unlock
+}
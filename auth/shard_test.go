@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestShardIndexIsStable(t *testing.T) {
+	used := make(map[int]bool)
+	for i := range 1000 {
+		key := fmt.Sprintf("user-%d", i)
+		idx := shardIndex(key)
+		if idx < 0 || idx >= shardCount {
+			t.Fatalf("shardIndex(%q) = %d", key, idx)
+		}
+		if shardIndex(key) != idx {
+			t.Fatalf("shardIndex(%q) が呼ぶたびに変わる", key)
+		}
+		used[idx] = true
+	}
+	// キーが偏らず、全てのシャードに散らばる
+	if len(used) != shardCount {
+		t.Errorf("使われたシャード %d / %d", len(used), shardCount)
+	}
+}
+
+// 同じキーの「読んで → 書き戻す」は直列になる（-race で確かめる）
+func TestKeyedMutexSerializesSameKey(t *testing.T) {
+	var m keyedMutex
+	counts := map[string]*int{"taro": new(int), "hanako": new(int), "jiro": new(int)} // map 自体は読むだけ
+	keys := []string{"taro", "hanako", "jiro"}
+
+	var wg sync.WaitGroup
+	for i := range 300 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := keys[i%len(keys)]
+			unlock := m.Lock(key)
+			defer unlock()
+			n := *counts[key]
+			*counts[key] = n + 1
+		}()
+	}
+	wg.Wait()
+	for _, key := range keys {
+		if *counts[key] != 100 {
+			t.Errorf("%s: %d, want 100", key, *counts[key])
+		}
+	}
+}
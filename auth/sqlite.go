@@ -0,0 +1,640 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ===================
+// SQLite の保存先
+// ===================
+
+// インメモリのストアは再起動でユーザーもセッションも消える。
+// SQLite に保存すれば、ファイル1つでサーバーを再起動しても残る。
+//
+// 「同じユーザー名は1人だけ」はDBの UNIQUE 制約で保証する。
+// アプリ側で「存在チェック → 追加」をしても、その間に別のリクエストが割り込めるため。
+
+// DBファイルを開き、未適用のマイグレーションを流す
+func OpenSQLite(path string) (*sql.DB, error) {
+	db, err := openSQLiteDB(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// 同時に起動した別のサーバーがマイグレーション中なら、終わるまで待つ
+	deadline := time.Now().Add(migrationLockWait)
+	for {
+		_, err = MigrateUp(db, MigrateOptions{})
+		var locked *MigrationLockedError
+		if !errors.As(err, &locked) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := assignUserIDs(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	conflicts, err := canonicalizeUsernames(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, c := range conflicts {
+		log.Printf("ユーザー名を正規化できません（このユーザーはログインできないので、管理画面で名前を変えてください）: %s", c)
+	}
+	return db, nil
+}
+
+// ID のないユーザー（0007 より前に登録された人）に ULID を割り当てる
+func assignUserIDs(db *sql.DB) error {
+	rows, err := db.Query(`SELECT id FROM users WHERE public_id = ''`)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		// 同時に起動した別のサーバーが先に割り当てていたら、そのままにする
+		if _, err := db.Exec(`UPDATE users SET public_id = ? WHERE id = ? AND public_id = ''`, newUserID(), id); err != nil {
+			return err
+		}
+	}
+	if len(ids) > 0 {
+		log.Printf("%d 人のユーザーに ID を割り当てました", len(ids))
+	}
+	return nil
+}
+
+// 正規化できなかったユーザー名
+type usernameConflict struct {
+	UserID   string // 公開用の ID（管理 API の名前の変更に使う）
+	Username string // DB に残っている名前
+	Reason   string
+}
+
+func (c usernameConflict) String() string {
+	return fmt.Sprintf("%s (%q): %s", c.UserID, c.Username, c.Reason)
+}
+
+// 0003 の lower() は ASCII しか小文字にしないので、全角・合字などの名前は正規化されずに残っている。
+// 検索のキーは CanonicalUsername なので、そのままではログインできない。起動時に Go で正規化し直す。
+// 今のルールでは使えない名前や、正規化すると別のユーザーとぶつかる名前は書き換えずに返す
+func canonicalizeUsernames(db *sql.DB) ([]usernameConflict, error) {
+	type row struct {
+		id       int64
+		publicID string
+		username string
+	}
+	rows, err := db.Query(`SELECT id, public_id, username FROM users`)
+	if err != nil {
+		return nil, err
+	}
+	var all []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.publicID, &r.username); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		all = append(all, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var conflicts []usernameConflict
+	fixed := 0
+	for _, r := range all {
+		canonical, err := CanonicalUsername(r.username)
+		if err != nil {
+			conflicts = append(conflicts, usernameConflict{r.publicID, r.username, err.Error()})
+			continue
+		}
+		if canonical == r.username {
+			continue
+		}
+		// 同時に起動した別のサーバーが先に書き換えていたら、そのままにする
+		_, err = db.Exec(`UPDATE users SET username = ? WHERE id = ? AND username = ?`, canonical, r.id, r.username)
+		if isUniqueViolation(err) {
+			conflicts = append(conflicts, usernameConflict{r.publicID, r.username, fmt.Sprintf("正規化した %q は別のユーザーが使っています", canonical)})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		fixed++
+	}
+	if fixed > 0 {
+		log.Printf("%d 人のユーザー名を正規化しました", fixed)
+	}
+	return conflicts, nil
+}
+
+// 起動時にマイグレーションのロックが外れるのを待つ時間
+const migrationLockWait = 30 * time.Second
+
+// DBファイルを開く（マイグレーションはしない）
+func openSQLiteDB(path string) (*sql.DB, error) {
+	// _foreign_keys: 外部キー制約を有効にする（SQLite はデフォルトで無効）
+	// _busy_timeout: 別の接続が書き込み中なら、エラーにせず最大5秒待つ
+	// _journal_mode=WAL: 書き込み中も読み込みを止めない
+	// _txlock=immediate: トランザクションの開始時に書き込みロックを取る。
+	//   読んでから書き込みに切り替えるトランザクションは、待たずに database is locked になることがあるため
+	dsn := fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate", path)
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite の書き込みは1つずつしかできず、ロック待ちは早い者勝ちではない（混むと5秒待っても順番が来ないことがある）。
+	// 接続を1本にして、プロセス内の待ち行列は database/sql の接続待ちに任せる
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// UNIQUE / PRIMARY KEY 制約違反か
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
+
+// --- ユーザー ---
+
+type SQLiteUserRepository struct {
+	db *sql.DB
+}
+
+func NewSQLiteUserRepository(db *sql.DB) *SQLiteUserRepository {
+	return &SQLiteUserRepository{db: db}
+}
+
+// ユーザーと履歴を1つのトランザクションで追加する（途中で失敗したら何も残らない）
+func (r *SQLiteUserRepository) Create(user *User) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO users (public_id, username, display_name, password_hash, password_changed_at, password_version,
+			email, email_verified_at, state, state_changed_at, password_reset_required, bootstrap_admin)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		user.ID, user.Username, user.DisplayName, user.PasswordHash, user.PasswordChangedAt, user.PasswordVersion,
+		user.Email, nullTime(user.EmailVerifiedAt), user.State, user.StateChangedAt, user.PasswordResetRequired, user.BootstrapAdmin)
+	if isUniqueViolation(err) {
+		// どちらの UNIQUE 制約に引っかかったかはメッセージでしかわからない
+		if strings.Contains(err.Error(), "users.email") {
+			return ErrEmailExists
+		}
+		if strings.Contains(err.Error(), "users.public_id") {
+			return fmt.Errorf("ユーザーID %s が重複しています", user.ID)
+		}
+		return ErrUserExists
+	}
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := insertHistory(tx, id, user.PasswordHistory); err != nil {
+		return err
+	}
+	if err := insertRoles(tx, id, user.Roles); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLiteUserRepository) Get(username string) (*User, error) {
+	return r.getUser("u.username = ?", username)
+}
+
+func (r *SQLiteUserRepository) GetByID(id string) (*User, error) {
+	return r.getUser("u.public_id = ?", id)
+}
+
+func (r *SQLiteUserRepository) GetByEmail(email string) (*User, error) {
+	return r.getUser("u.email = ? AND u.email <> ''", email)
+}
+
+// ユーザー・履歴・ロールを1つのクエリで読む（同じ時点のものが読める。トランザクションは書き込みロックを取るので使わない）
+func (r *SQLiteUserRepository) getUser(where string, arg any) (*User, error) {
+	rows, err := r.db.Query(`SELECT u.public_id, u.username, u.display_name, u.password_hash, u.password_changed_at, u.password_version,
+			u.email, u.email_verified_at, u.state, u.state_changed_at, u.password_reset_required, u.bootstrap_admin,
+			(SELECT group_concat(role, ',') FROM user_roles WHERE user_id = u.id),
+			h.password_hash
+		FROM users u LEFT JOIN password_history h ON h.user_id = u.id
+		WHERE `+where+` ORDER BY h.position`, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var user *User
+	for rows.Next() {
+		u := &User{}
+		var verifiedAt sql.NullTime // 未確認なら NULL
+		var roles sql.NullString    // ロールがなければ NULL
+		var old []byte              // 履歴がなければ NULL
+		if err := rows.Scan(&u.ID, &u.Username, &u.DisplayName, &u.PasswordHash, &u.PasswordChangedAt, &u.PasswordVersion,
+			&u.Email, &verifiedAt, &u.State, &u.StateChangedAt, &u.PasswordResetRequired, &u.BootstrapAdmin, &roles, &old); err != nil {
+			return nil, err
+		}
+		if user == nil {
+			u.EmailVerifiedAt = verifiedAt.Time
+			if roles.Valid {
+				u.Roles = strings.Split(roles.String, ",")
+			}
+			user = u
+		}
+		if old != nil {
+			user.PasswordHistory = append(user.PasswordHistory, old)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// ハッシュ・履歴・メールアドレスの確認日時・ロール・アカウントの状態をまとめて書き換える（ユーザー名とメールアドレスは変えない）
+func (r *SQLiteUserRepository) Update(user *User) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`UPDATE users SET password_hash = ?, password_changed_at = ?, password_version = ?, email_verified_at = ?,
+			state = ?, state_changed_at = ?, password_reset_required = ?
+		WHERE public_id = ? RETURNING id`,
+		user.PasswordHash, user.PasswordChangedAt, user.PasswordVersion, nullTime(user.EmailVerifiedAt),
+		user.State, user.StateChangedAt, user.PasswordResetRequired, user.ID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM password_history WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM user_roles WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if err := insertHistory(tx, id, user.PasswordHistory); err != nil {
+		return err
+	}
+	if err := insertRoles(tx, id, user.Roles); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 新しい名前が使われていれば、UNIQUE 制約で ErrUserExists になる
+func (r *SQLiteUserRepository) Rename(id, username, displayName string) error {
+	res, err := r.db.Exec(`UPDATE users SET username = ?, display_name = ? WHERE public_id = ?`, username, displayName, id)
+	if isUniqueViolation(err) {
+		return ErrUserExists
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// 履歴・セッション・ロール・使い捨てトークンは外部キー（ON DELETE CASCADE）で一緒に消える
+func (r *SQLiteUserRepository) Delete(id string) error {
+	res, err := r.db.Exec(`DELETE FROM users WHERE public_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// 絞り込んだユーザーの ID を1ページ分読み、1人ずつ GetByID で読む（1ページは最大100人なので十分）
+func (r *SQLiteUserRepository) List(query UserQuery) ([]*User, int, error) {
+	where := `1 = 1`
+	var args []any
+	if query.Search != "" {
+		// LIKE の % と _ は文字どおりに探す。SQLite の LIKE は ASCII の大文字小文字を区別しない
+		pattern := "%" + likeEscaper.Replace(query.Search) + "%"
+		where += ` AND (username LIKE ? ESCAPE '\' OR display_name LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\')`
+		args = append(args, pattern, pattern, pattern)
+	}
+	if query.State != "" {
+		where += ` AND state = ?`
+		args = append(args, query.State)
+	}
+	if !query.ChangedBefore.IsZero() {
+		where += ` AND state_changed_at < ?`
+		args = append(args, query.ChangedBefore)
+	}
+	if query.Role != "" {
+		where += ` AND EXISTS (SELECT 1 FROM user_roles r WHERE r.user_id = users.id AND r.role = ?)`
+		args = append(args, query.Role)
+	}
+
+	var total int
+	if err := r.db.QueryRow(`SELECT count(*) FROM users WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.Query(`SELECT public_id FROM users WHERE `+where+` ORDER BY username LIMIT ? OFFSET ?`,
+		append(args, query.Limit, max(query.Offset, 0))...)
+	if err != nil {
+		return nil, 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	users := make([]*User, 0, len(ids))
+	for _, id := range ids {
+		user, err := r.GetByID(id)
+		if errors.Is(err, ErrUserNotFound) {
+			continue // 読む間に削除された
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+	return users, total, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ゼロ値の日時は NULL として保存する
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func insertRoles(tx *sql.Tx, userID int64, roles []string) error {
+	for _, role := range roles {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO user_roles (user_id, role) VALUES (?, ?)`, userID, role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertHistory(tx *sql.Tx, userID int64, history [][]byte) error {
+	for i, hash := range history {
+		if _, err := tx.Exec(`INSERT INTO password_history (user_id, position, password_hash) VALUES (?, ?, ?)`,
+			userID, i, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- セッション ---
+
+type SQLiteSessionRepository struct {
+	db *sql.DB
+}
+
+func NewSQLiteSessionRepository(db *sql.DB) *SQLiteSessionRepository {
+	return &SQLiteSessionRepository{db: db}
+}
+
+func (r *SQLiteSessionRepository) Create(userID string) (*Session, error) {
+	id, err := generateSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &Session{
+		ID:        id,
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(SessionTTL),
+	}
+	res, err := r.db.Exec(`INSERT INTO sessions (id, user_id, created_at, expires_at)
+		SELECT ?, id, ?, ? FROM users WHERE public_id = ?`,
+		session.ID, session.CreatedAt, session.ExpiresAt, userID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrUserNotFound
+	}
+	return session, nil
+}
+
+func (r *SQLiteSessionRepository) Get(id string) (*Session, error) {
+	session := &Session{}
+	err := r.db.QueryRow(`SELECT s.id, u.public_id, s.created_at, s.expires_at
+		FROM sessions s JOIN users u ON u.id = s.user_id WHERE s.id = ?`, id).
+		Scan(&session.ID, &session.UserID, &session.CreatedAt, &session.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("セッションが見つかりません")
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 有効期限チェック
+	if time.Now().After(session.ExpiresAt) {
+		r.Delete(id)
+		return nil, fmt.Errorf("セッションが期限切れです")
+	}
+	return session, nil
+}
+
+func (r *SQLiteSessionRepository) Delete(id string) {
+	r.db.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+}
+
+// user_id の索引（sessions_user_id）があるので、セッションが多くても全件は見ない
+func (r *SQLiteSessionRepository) DeleteUser(userID string) (int, error) {
+	res, err := r.db.Exec(`DELETE FROM sessions WHERE user_id = (SELECT id FROM users WHERE public_id = ?)`, userID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (r *SQLiteSessionRepository) ListUser(userID string) ([]*Session, error) {
+	rows, err := r.db.Query(`SELECT s.id, u.public_id, s.created_at, s.expires_at
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE u.public_id = ? AND s.expires_at > ? ORDER BY s.created_at`, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var sessions []*Session
+	for rows.Next() {
+		session := &Session{}
+		if err := rows.Scan(&session.ID, &session.UserID, &session.CreatedAt, &session.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// --- 使い捨てトークン ---
+
+type SQLiteOneTimeTokenRepository struct {
+	db *sql.DB
+}
+
+func NewSQLiteOneTimeTokenRepository(db *sql.DB) *SQLiteOneTimeTokenRepository {
+	return &SQLiteOneTimeTokenRepository{db: db}
+}
+
+func (r *SQLiteOneTimeTokenRepository) Create(token *OneTimeToken) error {
+	// 使われずに期限が切れたものは、ここで掃除する
+	if _, err := r.db.Exec(`DELETE FROM one_time_tokens WHERE expires_at < ?`, time.Now()); err != nil {
+		return err
+	}
+	res, err := r.db.Exec(`INSERT INTO one_time_tokens (token_hash, purpose, user_id, expires_at)
+		SELECT ?, ?, id, ? FROM users WHERE public_id = ?`,
+		token.Hash, token.Purpose, token.ExpiresAt, token.UserID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// 削除と取り出しを1つの文で行う（同じトークンを同時に使っても、行を受け取れるのは1つだけ）
+func (r *SQLiteOneTimeTokenRepository) Consume(hash, purpose string) (*OneTimeToken, error) {
+	token := &OneTimeToken{Hash: hash, Purpose: purpose}
+	err := r.db.QueryRow(`DELETE FROM one_time_tokens WHERE token_hash = ? AND purpose = ?
+		RETURNING (SELECT public_id FROM users WHERE id = user_id), expires_at`, hash, purpose).
+		Scan(&token.UserID, &token.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (r *SQLiteOneTimeTokenRepository) DeleteUser(userID, purpose string) error {
+	_, err := r.db.Exec(`DELETE FROM one_time_tokens
+		WHERE purpose = ? AND user_id = (SELECT id FROM users WHERE public_id = ?)`, purpose, userID)
+	return err
+}
+
+// --- ログイン履歴 ---
+
+type SQLiteLoginHistoryRepository struct {
+	db *sql.DB
+}
+
+func NewSQLiteLoginHistoryRepository(db *sql.DB) *SQLiteLoginHistoryRepository {
+	return &SQLiteLoginHistoryRepository{db: db}
+}
+
+// 追加と古いものの削除を1つのトランザクションで行う（同時にログインしても上限を超えて残らない）
+func (r *SQLiteLoginHistoryRepository) Record(event LoginEvent) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`INSERT INTO login_history (user_id, logged_in_at, ip, user_agent)
+		SELECT id, ?, ?, ? FROM users WHERE public_id = ? RETURNING user_id`,
+		event.Time, event.IP, event.UserAgent, event.UserID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM login_history WHERE user_id = ? AND id NOT IN
+		(SELECT id FROM login_history WHERE user_id = ? ORDER BY logged_in_at DESC, id DESC LIMIT ?)`,
+		id, id, LoginHistoryLimit); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLiteLoginHistoryRepository) List(userID string) ([]LoginEvent, error) {
+	rows, err := r.db.Query(`SELECT h.logged_in_at, h.ip, h.user_agent
+		FROM login_history h JOIN users u ON u.id = h.user_id
+		WHERE u.public_id = ? ORDER BY h.logged_in_at DESC, h.id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []LoginEvent{}
+	for rows.Next() {
+		e := LoginEvent{UserID: userID}
+		if err := rows.Scan(&e.Time, &e.IP, &e.UserAgent); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (r *SQLiteLoginHistoryRepository) DeleteUser(userID string) error {
+	_, err := r.db.Exec(`DELETE FROM login_history WHERE user_id = (SELECT id FROM users WHERE public_id = ?)`, userID)
+	return err
+}
@@ -0,0 +1,485 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shin6142/go-login/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ===================
+// 並行アクセスのストレステスト
+// ===================
+
+// ストアを多数の goroutine から同時に読み書きして、壊れていないかを確認する。
+// データ競合は -race をつけて実行したときに検出される（見つかればそこで落ちる）。
+// 各シナリオはインメモリと SQLite の両方で回す。-short ではユーザー数・繰り返し回数を減らす。
+//
+//	go test -race -run Stress ./
+//
+// ハッシュ計算は重いので、bcrypt の最小コストで回す（確認したいのはロックの方）。
+
+// 取り込み時のコスト。ストアのコストより低いので、ログインのたびに再ハッシュが走る
+const (
+	importCost = bcrypt.MinCost
+	storeCost  = bcrypt.MinCost + 1
+)
+
+const stressWorkers = 32 // 同時に動かす goroutine の数
+
+// 登録するユーザー数
+func stressUsers() int {
+	if testing.Short() {
+		return 40
+	}
+	return 200
+}
+
+// ログイン中のパスワード変更を繰り返す回数
+func stressRounds() int {
+	if testing.Short() {
+		return 3
+	}
+	return 20
+}
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard) // 登録・変更のたびに出るログを止める
+	os.Exit(m.Run())
+}
+
+type repositories struct {
+	users        auth.UserRepository
+	sessions     auth.SessionRepository
+	userCount    func() int
+	sessionCount func() int
+}
+
+// インメモリと SQLite のそれぞれで f を実行する
+func forEachBackend(t *testing.T, f func(t *testing.T, backend string)) {
+	for _, backend := range []string{"memory", "sqlite"} {
+		t.Run(backend, func(t *testing.T) { f(t, backend) })
+	}
+}
+
+// 保存先を新しく作る（SQLite は毎回別のDBファイル）
+func newRepositories(t *testing.T, backend string) *repositories {
+	t.Helper()
+	if backend == "memory" {
+		users, sessions := auth.NewMemoryUserRepository(), auth.NewSessionStore()
+		return &repositories{users, sessions, users.Len, sessions.Len}
+	}
+
+	db, err := auth.OpenSQLite(filepath.Join(t.TempDir(), "stress.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	count := func(table string) func() int {
+		return func() int {
+			var n int
+			db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n)
+			return n
+		}
+	}
+	return &repositories{
+		users:        auth.NewSQLiteUserRepository(db),
+		sessions:     auth.NewSQLiteSessionRepository(db),
+		userCount:    count("users"),
+		sessionCount: count("sessions"),
+	}
+}
+
+func newStore(t *testing.T, backend string) (*auth.UserStore, *repositories) {
+	t.Helper()
+	repos := newRepositories(t, backend)
+	pool := auth.NewHashPool(stressWorkers, time.Minute)
+	hasher := auth.NewPooledHasher(auth.NewBcryptHasher(storeCost, false), pool)
+	store, err := auth.NewUserStore(repos.users, hasher, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, repos
+}
+
+// n 個の goroutine で f(i) を同時に実行し、全部終わるまで待つ
+func parallel(n int, f func(i int)) {
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start // 全員そろってから一斉に始める
+			f(i)
+		}()
+	}
+	close(start)
+	wg.Wait()
+}
+
+// --- シナリオ ---
+
+// 別々のユーザーを同時に登録 → 全員が保存されている
+func TestStressRegisterDistinct(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		store, repos := newStore(t, backend)
+		users := stressUsers()
+		var failed atomic.Int64
+		parallel(users, func(i int) {
+			if err := store.Register(fmt.Sprintf("user%03d", i), "", "password"); err != nil {
+				failed.Add(1)
+			}
+		})
+		if failed.Load() > 0 || repos.userCount() != users {
+			t.Fatalf("失敗 %d 件 / 保存 %d 人（期待 %d 人）", failed.Load(), repos.userCount(), users)
+		}
+	})
+}
+
+// 同じユーザー名（表記違いを含む）を同時に登録 → 成功は1件だけ
+func TestStressRegisterSameName(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		store, repos := newStore(t, backend)
+		// 表記が違っても正規化すると同じ名前なので、これも1人だけ
+		spellings := []string{"taro", "Taro", "TARO", "ｔａｒｏ"}
+		var succeeded atomic.Int64
+		parallel(stressWorkers, func(i int) {
+			if store.Register(spellings[i%len(spellings)], "", fmt.Sprintf("password-%d", i)) == nil {
+				succeeded.Add(1)
+			}
+		})
+		if succeeded.Load() != 1 || repos.userCount() != 1 {
+			t.Fatalf("成功 %d 件 / 保存 %d 人（期待 1 件 / 1 人）", succeeded.Load(), repos.userCount())
+		}
+	})
+}
+
+// 別々のユーザーを同時に同じ名前に変える → 1人だけ成功し、名前からも ID からも同じユーザーが引ける
+func TestStressRenameSameName(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		store, _ := newStore(t, backend)
+		ids := make([]string, stressWorkers)
+		for i := range stressWorkers {
+			name := fmt.Sprintf("user%03d", i)
+			if err := store.Register(name, "", "password"); err != nil {
+				t.Fatal(err)
+			}
+			user, err := store.Get(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids[i] = user.ID
+		}
+
+		var succeeded atomic.Int64
+		winner := make([]bool, stressWorkers)
+		parallel(stressWorkers, func(i int) {
+			if _, err := store.Rename(ids[i], "Hanako"); err == nil {
+				succeeded.Add(1)
+				winner[i] = true
+			}
+		})
+		if succeeded.Load() != 1 {
+			t.Fatalf("成功 %d 件（期待 1 件）", succeeded.Load())
+		}
+
+		for i, id := range ids {
+			want := fmt.Sprintf("user%03d", i)
+			if winner[i] {
+				want = "hanako"
+			}
+			byID, err := store.GetByID(id)
+			if err != nil || byID.Username != want {
+				t.Fatalf("ID %s: ユーザー名が %q ではありません: %v", id, want, err)
+			}
+			byName, err := store.Get(want)
+			if err != nil || byName.ID != id {
+				t.Fatalf("%s: 名前から別のユーザーが引けます: %v", want, err)
+			}
+		}
+	})
+}
+
+// 退会したユーザーの削除と、管理者による取り消しを同時に行う
+// → 取り消せたユーザーは残り、それ以外は全員消える（取り消した直後に消されない）
+func TestStressPurgeWhileActivating(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		store, repos := newStore(t, backend)
+		users := stressUsers()
+		ids := make([]string, users)
+		for i := range users {
+			name := fmt.Sprintf("user%03d", i)
+			if err := store.Register(name, "", "password"); err != nil {
+				t.Fatal(err)
+			}
+			user, err := store.Get(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Transition(user.ID, auth.StateDeactivated, auth.ActorSelf, ""); err != nil {
+				t.Fatal(err)
+			}
+			ids[i] = user.ID
+		}
+
+		// 猶予期間なし。削除ジョブも複数同時に回す
+		purger := auth.NewAccountPurger(store, repos.sessions, nil, nil, 0, time.Hour)
+		var activated, purged atomic.Int64
+		restored := make([]bool, users)
+		parallel(users+stressWorkers, func(i int) {
+			if i >= users {
+				n, _ := purger.PurgeOnce()
+				purged.Add(int64(n))
+				return
+			}
+			if _, err := store.Transition(ids[i], auth.StateActive, auth.ActorSystem, ""); err == nil {
+				activated.Add(1)
+				restored[i] = true
+			}
+		})
+
+		if activated.Load()+purged.Load() != int64(users) || repos.userCount() != int(activated.Load()) {
+			t.Fatalf("取り消し %d 件 + 削除 %d 件 / 残り %d 人（期待 %d 件 / %d 人）",
+				activated.Load(), purged.Load(), repos.userCount(), users, activated.Load())
+		}
+		for i, id := range ids {
+			user, err := store.GetByID(id)
+			if restored[i] && (err != nil || user.State != auth.StateActive) {
+				t.Fatalf("ID %s: 取り消したのに残っていません: %v", id, err)
+			}
+			if !restored[i] && err == nil {
+				t.Fatalf("ID %s: 取り消していないのに残っています", id)
+			}
+		}
+	})
+}
+
+// 本人が同じアカウントの削除を同時に何度も送る（半分はパスワード違い）→ 成功は1件だけ
+func TestStressDeleteAccountTwice(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		store, repos := newStore(t, backend)
+		if err := store.Register("taro", "", "password"); err != nil {
+			t.Fatal(err)
+		}
+		user, err := store.Get("taro")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var succeeded atomic.Int64
+		parallel(stressWorkers, func(i int) {
+			password := "password"
+			if i%2 == 1 {
+				password = "wrong-password"
+			}
+			if store.DeleteAccount(user.ID, password) == nil {
+				succeeded.Add(1)
+			}
+		})
+		if succeeded.Load() != 1 || repos.userCount() != 0 {
+			t.Fatalf("成功 %d 件 / 残り %d 人（期待 1 件 / 0 人）", succeeded.Load(), repos.userCount())
+		}
+	})
+}
+
+// ログイン（再ハッシュ）とパスワード変更を同じユーザーに同時に行う
+// → 変更は1件だけ成功し、再ハッシュが新しいパスワードを古いもので上書きしない。
+// 再ハッシュは最初の数回のログインでしか起きないので、ストアを作り直して何度も繰り返す
+func TestStressLoginWhileChanging(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		for range stressRounds() {
+			loginWhileChangingOnce(t, backend)
+		}
+	})
+}
+
+func loginWhileChangingOnce(t *testing.T, backend string) {
+	store, _ := newStore(t, backend)
+	const initial = "initial-password"
+	hash, _ := bcrypt.GenerateFromPassword([]byte(initial), importCost)
+	if err := store.Import("hanako", hash); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu      sync.Mutex
+		changed []string // 変更に成功した新しいパスワード
+	)
+	parallel(stressWorkers, func(i int) {
+		if i%2 == 0 {
+			store.Authenticate("hanako", initial)
+			return
+		}
+		candidate := fmt.Sprintf("changed-%d", i)
+		if store.ChangePassword("hanako", initial, candidate) == nil {
+			mu.Lock()
+			changed = append(changed, candidate)
+			mu.Unlock()
+		}
+	})
+
+	// 2件以上成功した = 変更の後に古いパスワードのハッシュで上書きされた
+	if len(changed) != 1 {
+		t.Fatalf("パスワード変更の成功が %d 件（期待 1 件）", len(changed))
+	}
+	w := changed[0]
+	if _, err := store.Authenticate("hanako", w); err != nil {
+		t.Fatalf("変更後のパスワード（%s）でログインできない", w)
+	}
+	if _, err := store.Authenticate("hanako", initial); err == nil {
+		t.Fatal("変更前のパスワードでログインできてしまう")
+	}
+}
+
+// セッションの作成・取得・削除を同時に行う → 削除したものだけが消えている
+func TestStressSessions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		repos := newRepositories(t, backend)
+		users := stressUsers()
+		// SQLite のセッションはユーザーに紐づくので、先にユーザーを作っておく
+		for i := range users {
+			repos.users.Create(&auth.User{ID: fmt.Sprintf("id%03d", i), Username: fmt.Sprintf("user%03d", i), PasswordHash: []byte("-")})
+		}
+
+		store := repos.sessions
+		ids := make([]string, users)
+		parallel(users, func(i int) {
+			session, err := store.Create(fmt.Sprintf("id%03d", i))
+			if err == nil {
+				ids[i] = session.ID
+			}
+		})
+
+		var missing atomic.Int64
+		parallel(users, func(i int) {
+			if i%2 == 0 {
+				store.Delete(ids[i])
+				return
+			}
+			for range 10 {
+				if _, err := store.Get(ids[i]); err != nil {
+					missing.Add(1)
+				}
+			}
+		})
+
+		if missing.Load() > 0 || repos.sessionCount() != users/2 {
+			t.Fatalf("取得失敗 %d 件 / 残り %d 件（期待 %d 件）", missing.Load(), repos.sessionCount(), users/2)
+		}
+	})
+}
+
+// 同じアカウントへのログイン試行を同時に送る → 待たずに通るのは free_attempts 回だけ
+// （パスワードを検証してから数えると、同時に来た分が全部すり抜ける）
+func TestStressLoginFlood(t *testing.T) {
+	cfg := auth.DefaultConfig().Lockout
+	throttle := auth.NewLoginThrottle(cfg)
+	var allowed atomic.Int64
+	parallel(stressWorkers, func(i int) {
+		if throttle.Attempt("taro") == nil {
+			allowed.Add(1)
+		}
+	})
+	if allowed.Load() != int64(cfg.FreeAttempts) {
+		t.Fatalf("%d 回通った（期待 %d 回）", allowed.Load(), cfg.FreeAttempts)
+	}
+}
+
+// HTTPハンドラー経由で同じ名前を2回ずつ同時に登録 → 201 は名前の数だけ返る
+func TestStressHTTPRegister(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		store, repos := newStore(t, backend)
+		api := &auth.API{Users: store, Sessions: repos.sessions}
+		users := stressUsers()
+
+		var created atomic.Int64
+		parallel(users, func(i int) {
+			body, _ := json.Marshal(auth.AuthRequest{Username: fmt.Sprintf("user%03d", i%(users/2)), Password: "password"})
+			rec := httptest.NewRecorder()
+			api.HandleRegister(rec, httptest.NewRequest(http.MethodPost, "/register", bytes.NewReader(body)))
+			if rec.Code == http.StatusCreated {
+				created.Add(1)
+			}
+		})
+
+		// 同じ名前が2回ずつ送られるので、成功するのは半分
+		if int(created.Load()) != users/2 || repos.userCount() != users/2 {
+			t.Fatalf("201 が %d 件 / 保存 %d 人（期待 %d）", created.Load(), repos.userCount(), users/2)
+		}
+	})
+}
+
+// 応答時間からユーザーの存在がわからないか。
+// 「いないユーザー」と「パスワード違い」のログイン、「使われている名前」と「新しい名前」の登録で、
+// かかる時間の中央値の差が timingBound 以内に収まることを確かめる（ハッシュは本番に近いコストで計算する）。
+// 数十秒かかるので -short では飛ばす
+const (
+	timingCost    = bcrypt.DefaultCost - 2
+	timingSamples = 31
+	timingBound   = 0.15 // 遅い方に対する差の割合
+)
+
+func TestStressAuthTiming(t *testing.T) {
+	if testing.Short() {
+		t.Skip("時間がかかるので -short では飛ばす")
+	}
+	forEachBackend(t, func(t *testing.T, backend string) {
+		repos := newRepositories(t, backend)
+		hasher := auth.NewBcryptHasher(timingCost, false)
+		store, err := auth.NewUserStore(repos.users, hasher, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Register("hanako", "", "correct-password"); err != nil {
+			t.Fatal(err)
+		}
+
+		// 2つを交互に測る（途中でマシンが混んでも、片方だけが遅くならないように）
+		var unknown, wrong, taken, fresh []time.Duration
+		for i := range timingSamples {
+			unknown = append(unknown, measure(func() { store.Authenticate("nobody", "wrong-password") }))
+			wrong = append(wrong, measure(func() { store.Authenticate("hanako", "wrong-password") }))
+			taken = append(taken, measure(func() { store.Register("hanako", "", "some-password") }))
+			fresh = append(fresh, measure(func() { store.Register(fmt.Sprintf("timing%03d", i), "", "some-password") }))
+		}
+
+		compareTiming(t, "ログイン（いない / パスワード違い）", unknown, wrong)
+		compareTiming(t, "登録（使われている / 新しい）", taken, fresh)
+	})
+}
+
+func measure(f func()) time.Duration {
+	start := time.Now()
+	f()
+	return time.Since(start)
+}
+
+func median(samples []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), samples...)
+	slices.Sort(sorted)
+	return sorted[len(sorted)/2]
+}
+
+func compareTiming(t *testing.T, name string, a, b []time.Duration) {
+	t.Helper()
+	ma, mb := median(a), median(b)
+	diff := float64(max(ma, mb)-min(ma, mb)) / float64(max(ma, mb))
+	t.Logf("%s: %v / %v（差 %.1f%%）", name, ma, mb, diff*100)
+	if diff > timingBound {
+		t.Errorf("%s の時間が違いすぎる: %v / %v（差 %.1f%%、上限 %.0f%%）", name, ma, mb, diff*100, timingBound*100)
+	}
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ===================
+// ログイン試行の制限
+// ===================
+
+// 制限がないと、1つのアカウントに対してパスワードを何万回でも試せる（オンラインの総当たり）。
+// アカウントごとに失敗を数え、
+//   - free_attempts 回までは待たずに試せる
+//   - それを超えると、次に試せるまでの待ち時間を 1秒 → 2秒 → 4秒 … と倍にしていく（上限 backoff_max）
+//   - lockout_threshold 回失敗すると lockout_minutes の間ロックする（時間が経てば自動で外れる）
+//
+// 存在しないユーザー名も同じように数える。返事が同じになるので、ロックの有無からユーザーの存在はわからない。
+// ログインに成功すれば数え直す。
+//
+// 他人のアカウントをわざとロックさせる嫌がらせもできてしまうので、ロックは lockout_threshold を 0 にすれば無効にできる。
+
+type LoginThrottle struct {
+	shards [shardCount]throttleShard
+
+	freeAttempts     int
+	backoffBase      time.Duration
+	backoffMax       time.Duration
+	lockoutThreshold int // 0ならロックしない
+	lockoutDuration  time.Duration
+	resetAfter       time.Duration // 最後の失敗からこれだけ経てば数え直す
+}
+
+type throttleShard struct {
+	mu        sync.Mutex
+	accounts  map[string]*loginFailures // key: 正規化したユーザー名
+	lastSweep time.Time
+}
+
+type loginFailures struct {
+	count       int
+	lastAttempt time.Time
+	lockedUntil time.Time
+}
+
+func NewLoginThrottle(cfg LockoutConfig) *LoginThrottle {
+	t := &LoginThrottle{
+		freeAttempts:     cfg.FreeAttempts,
+		backoffBase:      time.Duration(cfg.BackoffBaseMs) * time.Millisecond,
+		backoffMax:       time.Duration(cfg.BackoffMaxSeconds) * time.Second,
+		lockoutThreshold: cfg.LockoutThreshold,
+		lockoutDuration:  time.Duration(cfg.LockoutMinutes) * time.Minute,
+		resetAfter:       time.Duration(cfg.ResetAfterMinutes) * time.Minute,
+	}
+	for i := range t.shards {
+		t.shards[i].accounts = make(map[string]*loginFailures)
+	}
+	return t
+}
+
+// 試行を断ったときのエラー（待ち時間の理由がバックオフでもロックでも同じ返事にする）
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return "ログインの試行回数が多すぎます。しばらくしてから再試行してください"
+}
+
+// Retry-After ヘッダーに入れる秒数（切り上げ、最低1秒）
+func (e *LoginThrottledError) RetryAfterSeconds() int {
+	return max(1, int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// パスワードを検証する前に呼ぶ。試してよければ失敗として先に数え、ダメなら LoginThrottledError を返す。
+// 検証の後で数えると、同時にたくさん送られたときに全部が制限をすり抜けてしまうため。
+// 成功したら Succeeded、検証できなかった（混雑など）なら Cancel で取り消す
+func (t *LoginThrottle) Attempt(username string) error {
+	now := time.Now()
+	sh := &t.shards[shardIndex(username)]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	t.sweep(sh, now)
+
+	// ロックが時間切れで外れたら、最初から数え直す
+	f, exists := sh.accounts[username]
+	if !exists || t.expired(f, now) || (!f.lockedUntil.IsZero() && !now.Before(f.lockedUntil)) {
+		f = &loginFailures{}
+		sh.accounts[username] = f
+	}
+
+	if now.Before(f.lockedUntil) {
+		return &LoginThrottledError{RetryAfter: f.lockedUntil.Sub(now)}
+	}
+	if next := t.nextAttemptAt(f); now.Before(next) {
+		return &LoginThrottledError{RetryAfter: next.Sub(now)}
+	}
+
+	f.count++
+	f.lastAttempt = now
+	if t.lockoutThreshold > 0 && f.count >= t.lockoutThreshold {
+		f.lockedUntil = now.Add(t.lockoutDuration)
+		log.Printf("ログイン失敗が %d 回続いたため %v ロック: %s", f.count, t.lockoutDuration, username)
+	}
+	return nil
+}
+
+// ログインに成功したら数え直す
+func (t *LoginThrottle) Succeeded(username string) {
+	sh := &t.shards[shardIndex(username)]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	delete(sh.accounts, username)
+}
+
+// 先に数えた分を取り消す（パスワードを検証できなかったとき）
+func (t *LoginThrottle) Cancel(username string) {
+	sh := &t.shards[shardIndex(username)]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if f, exists := sh.accounts[username]; exists && f.count > 0 {
+		f.count--
+		if t.lockoutThreshold > 0 && f.count < t.lockoutThreshold {
+			f.lockedUntil = time.Time{}
+		}
+	}
+}
+
+// 次に試せる日時（free_attempts 回までは待たない）
+func (t *LoginThrottle) nextAttemptAt(f *loginFailures) time.Time {
+	over := f.count - t.freeAttempts
+	if over < 0 {
+		return time.Time{}
+	}
+	wait := t.backoffMax
+	if over < 30 { // 2^30 倍を超えると time.Duration があふれる
+		wait = min(t.backoffBase<<over, t.backoffMax)
+	}
+	return f.lastAttempt.Add(wait)
+}
+
+// ロックが外れ、最後の失敗から resetAfter 経っていれば忘れてよい
+func (t *LoginThrottle) expired(f *loginFailures, now time.Time) bool {
+	return now.After(f.lockedUntil) && now.Sub(f.lastAttempt) > t.resetAfter
+}
+
+// 存在しないユーザー名でも記録が残るので、古いものはときどき消す（1分に1回、シャードごと）
+func (t *LoginThrottle) sweep(sh *throttleShard, now time.Time) {
+	if now.Sub(sh.lastSweep) < time.Minute {
+		return
+	}
+	sh.lastSweep = now
+	for username, f := range sh.accounts {
+		if t.expired(f, now) {
+			delete(sh.accounts, username)
+		}
+	}
+}
+
+// --- 管理者向け ---
+
+// 制限中のアカウント（存在しないユーザー名も含む。狙われている名前がわかる）
+type LockoutStatus struct {
+	Username      string    `json:"username"`
+	Failures      int       `json:"failures"`
+	LastAttempt   time.Time `json:"last_attempt"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	Locked        bool      `json:"locked"`
+}
+
+// いま待たされている・ロックされているアカウントの一覧（失敗の多い順）
+func (t *LoginThrottle) Lockouts() []LockoutStatus {
+	now := time.Now()
+	list := []LockoutStatus{}
+	for i := range t.shards {
+		sh := &t.shards[i]
+		sh.mu.Lock()
+		for username, f := range sh.accounts {
+			next := t.nextAttemptAt(f)
+			locked := now.Before(f.lockedUntil)
+			if locked {
+				next = f.lockedUntil
+			}
+			if !now.Before(next) {
+				continue
+			}
+			list = append(list, LockoutStatus{
+				Username:      username,
+				Failures:      f.count,
+				LastAttempt:   f.lastAttempt,
+				NextAttemptAt: next,
+				Locked:        locked,
+			})
+		}
+		sh.mu.Unlock()
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Failures > list[j].Failures })
+	return list
+}
+
+// 一覧を返す。誰でも見られないように、Require(PermLockoutsRead, ...) か LocalOnly で包んで使う
+func (t *LoginThrottle) HandleLockouts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t.Lockouts())
+}
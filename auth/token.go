@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ===================
+// JWT関連
+// ===================
+
+// TokenIssuer はステートレスなトークンの発行と検証を行う
+type TokenIssuer interface {
+	Issue(userID string, passwordVersion int) (string, error) // userID は sub、passwordVersion は pwv に入れる
+	Verify(token string) (*Payload, error)
+}
+
+type Header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+type Payload struct {
+	Subject string `json:"sub"` // ユーザーID（ユーザー名は変えられるので入れない）
+	Exp     int64  `json:"exp"`
+	Iat     int64  `json:"iat"`
+
+	// 発行したときの User.PasswordVersion。iat（秒単位）で比べると、
+	// パスワードを変更したのと同じ秒に発行されたトークンを弾けないので、番号で比べる
+	PasswordVersion int `json:"pwv"`
+}
+
+// HMAC-SHA256 で署名する JWT
+type HS256Issuer struct {
+	secret []byte        // 秘密鍵（本番環境では環境変数から取得すること！）
+	ttl    time.Duration // トークンの有効期限
+}
+
+func NewHS256Issuer(secret []byte, ttl time.Duration) *HS256Issuer {
+	return &HS256Issuer{secret: secret, ttl: ttl}
+}
+
+func base64URLEncode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func base64URLDecode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+func (i *HS256Issuer) sign(header, payload string) string {
+	message := header + "." + payload
+	h := hmac.New(sha256.New, i.secret)
+	h.Write([]byte(message))
+	return base64URLEncode(h.Sum(nil))
+}
+
+func (i *HS256Issuer) Issue(userID string, passwordVersion int) (string, error) {
+	header := Header{Alg: "HS256", Typ: "JWT"}
+	headerJSON, _ := json.Marshal(header)
+	headerEncoded := base64URLEncode(headerJSON)
+
+	now := time.Now()
+	payload := Payload{
+		Subject: userID,
+		Exp:     now.Add(i.ttl).Unix(),
+		Iat:     now.Unix(),
+
+		PasswordVersion: passwordVersion,
+	}
+	payloadJSON, _ := json.Marshal(payload)
+	payloadEncoded := base64URLEncode(payloadJSON)
+
+	signature := i.sign(headerEncoded, payloadEncoded)
+
+	return headerEncoded + "." + payloadEncoded + "." + signature, nil
+}
+
+func (i *HS256Issuer) Verify(token string) (*Payload, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("無効なトークン形式")
+	}
+
+	// 署名を検証（比較は一定時間で行う）
+	signatureExpected := i.sign(parts[0], parts[1])
+	if !hmac.Equal([]byte(parts[2]), []byte(signatureExpected)) {
+		return nil, fmt.Errorf("署名が無効です")
+	}
+
+	// Payloadをデコード
+	payloadJSON, err := base64URLDecode(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("Payloadのデコードに失敗")
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(payloadJSON, &payload); err != nil {
+		return nil, fmt.Errorf("Payloadのパースに失敗")
+	}
+
+	// 有効期限をチェック
+	if time.Now().Unix() > payload.Exp {
+		return nil, fmt.Errorf("トークンが期限切れです")
+	}
+
+	return &payload, nil
+}
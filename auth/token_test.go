@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHS256IssuerRoundTrip(t *testing.T) {
+	issuer := NewHS256Issuer([]byte("test-secret"), time.Hour)
+	token, err := issuer.Issue("01K7USERID", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := issuer.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Subject != "01K7USERID" || payload.PasswordVersion != 3 {
+		t.Errorf("payload = %+v", payload)
+	}
+	if payload.Exp-payload.Iat != int64(time.Hour/time.Second) {
+		t.Errorf("有効期限 = %d 秒, want 3600", payload.Exp-payload.Iat)
+	}
+}
+
+// 改ざん・別の鍵・期限切れ・形式違いのトークンは受け付けない
+func TestHS256IssuerRejectsInvalidTokens(t *testing.T) {
+	issuer := NewHS256Issuer([]byte("test-secret"), time.Hour)
+	token, err := issuer.Issue("01K7USERID", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+
+	// sub を書き換えて別人になりすます
+	forged := base64URLEncode([]byte(`{"sub":"01K7ADMINID","exp":9999999999,"iat":0,"pwv":0}`))
+	otherKey, _ := NewHS256Issuer([]byte("other-secret"), time.Hour).Issue("01K7USERID", 0)
+	expired, _ := NewHS256Issuer([]byte("test-secret"), -time.Minute).Issue("01K7USERID", 0)
+
+	for _, tc := range []struct {
+		name, token string
+	}{
+		{"ペイロードの改ざん", parts[0] + "." + forged + "." + parts[2]},
+		{"署名なし", parts[0] + "." + parts[1] + "."},
+		{"別の鍵で署名", otherKey},
+		{"期限切れ", expired},
+		{"区切りが足りない", parts[0] + "." + parts[1]},
+		{"空", ""},
+	} {
+		if _, err := issuer.Verify(tc.token); err == nil {
+			t.Errorf("%s: 受け付けた", tc.name)
+		}
+	}
+}
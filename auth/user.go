@@ -0,0 +1,756 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// ===================
+// ユーザー管理
+// ===================
+
+var (
+	// ログイン失敗はユーザーがいないときもパスワードが違うときも同じエラーにする（どちらか教えない）
+	ErrAuthFailed = errors.New("ユーザー名またはパスワードが間違っています")
+
+	ErrUserExists   = errors.New("ユーザーは既に存在します")
+	ErrEmailExists  = errors.New("メールアドレスは既に登録されています")
+	ErrUserNotFound = errors.New("ユーザーが見つかりません")
+
+	// パスワードが合っていたときだけ返す（リセットが必要かどうかを、パスワードを知らない人には教えない）
+	ErrPasswordResetRequired = errors.New("パスワードのリセットが必要です。/password/forgot からリセットしてください")
+
+	// パスワード変更で、現在のパスワードが違った（いないユーザーでも同じ）
+	ErrWrongCurrentPassword = errors.New("現在のパスワードが間違っています")
+
+	// パスワードを確かめてから保存するまでの間に、別のリクエストがパスワードを変えた
+	ErrPasswordChanged = errors.New("パスワードが同時に変更されました。もう一度やり直してください")
+)
+
+type User struct {
+	ID                string    // 変わらないユーザーID（ULID）。セッション・トークンはこれで本人を指す
+	Username          string    // 正規化したユーザー名（ログインに使う。変えられる。CanonicalUsername を参照）
+	DisplayName       string    // 登録時に入力されたままの名前（表示用）
+	PasswordHash      []byte    // ハッシュ化されたパスワード（平文は保存しない！）
+	PasswordHistory   [][]byte  // 過去のパスワードハッシュ（新しい順）
+	PasswordChangedAt time.Time // パスワードを最後に設定した日時
+	PasswordVersion   int       // パスワードを設定し直すたびに1増える（JWT に入れ、変更前に発行されたものを弾く）
+	Email             string    // 小文字にそろえたメールアドレス（空なら未登録。旧システムから取り込んだユーザーなど）
+	EmailVerifiedAt   time.Time // メールアドレスを確認した日時（ゼロ値なら未確認）
+	Roles             []string  // ロール（権限は Roles で決まる）
+
+	State                 AccountState // pending / active / suspended / deactivated（lifecycle.go）
+	StateChangedAt        time.Time    // 今の状態になった日時（退会後の削除はここから数える）
+	PasswordResetRequired bool         // 管理者がリセットを求めた（リセットするまでログインできない）
+	BootstrapAdmin        bool         // 起動時に BootstrapAdmin が作った初期管理者（作った後は変わらない）
+}
+
+// 新しいユーザーID（ULID: 時刻順に並ぶ26文字。DBの連番と違い、保存先を移しても変わらない）
+func newUserID() string {
+	return ulid.Make().String()
+}
+
+// メールアドレスを確認するまでログインさせない。メールアドレスのないユーザーは確認しようがないので対象外
+func (u *User) NeedsEmailVerification() bool {
+	return u.Email != "" && u.EmailVerifiedAt.IsZero()
+}
+
+// UserRepository はユーザーの保存先。
+// Get が返す *User はコピーなので、変更したら Update で書き戻す
+type UserRepository interface {
+	Create(user *User) error // 既に存在すれば ErrUserExists、メールアドレスが使われていれば ErrEmailExists
+	Get(username string) (*User, error)
+	GetByID(id string) (*User, error)
+	GetByEmail(email string) (*User, error)
+	Update(user *User) error                       // ID で探して書き換える。ユーザー名とメールアドレスは変えない
+	Rename(id, username, displayName string) error // 新しい名前が使われていれば ErrUserExists
+	Delete(id string) error
+	List(query UserQuery) (users []*User, total int, err error) // ユーザー名の順。total は絞り込んだ後の全件数
+}
+
+// ユーザー一覧の絞り込みとページ分け
+type UserQuery struct {
+	Search        string       // ユーザー名・表示名・メールアドレスの部分一致（大文字小文字は区別しない。空なら全員）
+	State         AccountState // この状態のユーザーだけ（空なら全員）
+	Role          string       // このロールを持つユーザーだけ（空なら全員）
+	ChangedBefore time.Time    // この日時より前に今の状態になったユーザーだけ（ゼロ値なら全員）
+	Offset        int
+	Limit         int
+}
+
+func (q UserQuery) matches(u *User) bool {
+	search := strings.ToLower(q.Search)
+	if search != "" &&
+		!strings.Contains(u.Username, search) &&
+		!strings.Contains(strings.ToLower(u.DisplayName), search) &&
+		!strings.Contains(u.Email, search) {
+		return false
+	}
+	if q.State != "" && u.State != q.State {
+		return false
+	}
+	if q.Role != "" && !slices.Contains(u.Roles, q.Role) {
+		return false
+	}
+	return q.ChangedBefore.IsZero() || u.StateChangedAt.Before(q.ChangedBefore)
+}
+
+// --- インメモリの保存先 ---
+
+// ユーザーIDのハッシュでシャードに分けて、それぞれを別のロックで守る
+type MemoryUserRepository struct {
+	shards [shardCount]userShard
+
+	// ユーザー名・メールアドレス → ID。シャードをまたぐので別のロックで守る（取る順番は必ずシャード → indexMu）
+	indexMu sync.RWMutex
+	names   map[string]string
+	emails  map[string]string
+}
+
+type userShard struct {
+	mu    sync.RWMutex
+	users map[string]*User // key: ユーザーID
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	r := &MemoryUserRepository{names: make(map[string]string), emails: make(map[string]string)}
+	for i := range r.shards {
+		r.shards[i].users = make(map[string]*User)
+	}
+	return r
+}
+
+func (r *MemoryUserRepository) shard(id string) *userShard {
+	return &r.shards[shardIndex(id)]
+}
+
+func (r *MemoryUserRepository) Create(user *User) error {
+	sh := r.shard(user.ID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	r.indexMu.Lock()
+	defer r.indexMu.Unlock()
+	// 存在チェックと追加を同じロックの中で行う（同じ名前の同時登録はどちらか一方だけ成功する）
+	if _, exists := r.names[user.Username]; exists {
+		return ErrUserExists
+	}
+	if _, exists := sh.users[user.ID]; exists {
+		return ErrUserExists
+	}
+	if user.Email != "" {
+		if _, exists := r.emails[user.Email]; exists {
+			return ErrEmailExists
+		}
+		r.emails[user.Email] = user.ID
+	}
+	r.names[user.Username] = user.ID
+	sh.users[user.ID] = cloneUser(user)
+	return nil
+}
+
+func (r *MemoryUserRepository) Get(username string) (*User, error) {
+	r.indexMu.RLock()
+	id, exists := r.names[username]
+	r.indexMu.RUnlock()
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	return r.GetByID(id)
+}
+
+func (r *MemoryUserRepository) GetByID(id string) (*User, error) {
+	sh := r.shard(id)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	user, exists := sh.users[id]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	return cloneUser(user), nil
+}
+
+func (r *MemoryUserRepository) GetByEmail(email string) (*User, error) {
+	r.indexMu.RLock()
+	id, exists := r.emails[email]
+	r.indexMu.RUnlock()
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	return r.GetByID(id)
+}
+
+func (r *MemoryUserRepository) Update(user *User) error {
+	sh := r.shard(user.ID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	current, exists := sh.users[user.ID]
+	if !exists {
+		return ErrUserNotFound
+	}
+	// 索引とずれないように、ユーザー名とメールアドレスは変えない（名前は Rename で変える）
+	updated := cloneUser(user)
+	updated.Username = current.Username
+	updated.DisplayName = current.DisplayName
+	updated.Email = current.Email
+	sh.users[user.ID] = updated
+	return nil
+}
+
+func (r *MemoryUserRepository) Rename(id, username, displayName string) error {
+	sh := r.shard(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	current, exists := sh.users[id]
+	if !exists {
+		return ErrUserNotFound
+	}
+	r.indexMu.Lock()
+	defer r.indexMu.Unlock()
+	if other, exists := r.names[username]; exists && other != id {
+		return ErrUserExists
+	}
+	delete(r.names, current.Username)
+	r.names[username] = id
+	updated := cloneUser(current)
+	updated.Username = username
+	updated.DisplayName = displayName
+	sh.users[id] = updated
+	return nil
+}
+
+func (r *MemoryUserRepository) Delete(id string) error {
+	sh := r.shard(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	user, exists := sh.users[id]
+	if !exists {
+		return ErrUserNotFound
+	}
+	r.indexMu.Lock()
+	delete(r.names, user.Username)
+	if user.Email != "" {
+		delete(r.emails, user.Email)
+	}
+	r.indexMu.Unlock()
+	delete(sh.users, id)
+	return nil
+}
+
+// 全シャードを見て絞り込み、ユーザー名で並べてから切り出す
+func (r *MemoryUserRepository) List(query UserQuery) ([]*User, int, error) {
+	var matched []*User
+	for i := range r.shards {
+		sh := &r.shards[i]
+		sh.mu.RLock()
+		for _, u := range sh.users {
+			if query.matches(u) {
+				matched = append(matched, cloneUser(u))
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Username < matched[j].Username })
+
+	total := len(matched)
+	start := min(max(query.Offset, 0), total)
+	end := min(start+query.Limit, total)
+	return matched[start:end], total, nil
+}
+
+// 登録されているユーザー数
+func (r *MemoryUserRepository) Len() int {
+	n := 0
+	for i := range r.shards {
+		sh := &r.shards[i]
+		sh.mu.RLock()
+		n += len(sh.users)
+		sh.mu.RUnlock()
+	}
+	return n
+}
+
+// 呼び出し側が書き換えても保存先に影響しないようにコピーする
+func cloneUser(u *User) *User {
+	c := *u
+	c.PasswordHash = bytes.Clone(u.PasswordHash)
+	c.PasswordHistory = make([][]byte, len(u.PasswordHistory))
+	for i, h := range u.PasswordHistory {
+		c.PasswordHistory[i] = bytes.Clone(h)
+	}
+	c.Roles = append([]string(nil), u.Roles...)
+	return &c
+}
+
+// --- UserStore ---
+
+// UserStore は登録・ログイン・パスワード変更のルールをまとめたもの。
+// 保存は UserRepository に任せるので、インメモリでもDBでも同じルールになる
+type UserStore struct {
+	repo   UserRepository
+	hasher PasswordHasher  // パスワードのハッシュ化・検証方法
+	policy *PasswordPolicy // パスワードポリシー（nilならチェックしない）
+	locks  keyedMutex      // 同じユーザーの更新（変更・再ハッシュなど）を直列にする（key: ユーザーID。名前が変わっても同じロック）
+	audit  AuditLog        // 状態の変化を残す先
+
+	// 本人による削除を直列にする（管理者が2人同時に自分を消すと、どちらも「ほかに管理者がいる」と判断してしまう）。
+	// 取る順番は必ず selfDeleteMu → locks
+	selfDeleteMu sync.Mutex
+
+	// 新規登録をメールアドレスの確認待ち（pending）にし、確認するまでログインさせない
+	verifyEmail bool
+
+	// 存在しないユーザーのログインでも検証にかけるダミーのハッシュ（起動時に1回だけ作る）。
+	// 検証をとばすとすぐに返事が返るので、応答時間からユーザーの存在がわかってしまう
+	dummyHash []byte
+}
+
+// ダミーのハッシュを作れなければエラーにする（ないまま動かすと、いないユーザーの検証がすぐに終わってしまう）
+func NewUserStore(repo UserRepository, hasher PasswordHasher, policy *PasswordPolicy) (*UserStore, error) {
+	s := &UserStore{repo: repo, hasher: hasher, policy: policy, audit: stdAuditLog{}}
+	// 新規登録と同じ形式・コストで作るので、検証にかかる時間も本物のユーザーと同じになる
+	dummy, err := hasher.Hash("dummy-password-for-unknown-users")
+	if err != nil {
+		return nil, fmt.Errorf("ダミーハッシュの作成に失敗: %w", err)
+	}
+	s.dummyHash = dummy
+	return s, nil
+}
+
+// ユーザー登録（Taro と taro のように正規化して同じになる名前は、同じユーザーとみなす）。
+// email は空でもよい（必須にするかどうかは呼び出し側で決める）。
+// 既に使われていれば ErrUserExists / ErrEmailExists を返すが、それがわかるのはハッシュ化まで済ませた後。
+// 先に調べて返すと、応答の速さで使われている名前がわかってしまう
+func (s *UserStore) Register(username, email, password string) error {
+	return s.register(username, email, password, nil)
+}
+
+// setup は保存する直前のユーザーを書き換える（初期管理者のロールなど、作るときに一緒に保存したいもの）
+func (s *UserStore) register(username, email, password string, setup func(user *User)) error {
+	canonical, err := CanonicalUsername(username)
+	if err != nil {
+		return err
+	}
+	if email != "" {
+		if email, err = NormalizeEmail(email); err != nil {
+			return err
+		}
+	}
+
+	// パスワードポリシーのチェック
+	if s.policy != nil {
+		if err := s.policy.Validate(canonical, password); err != nil {
+			return err
+		}
+	}
+
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return fmt.Errorf("パスワードのハッシュ化に失敗: %w", err)
+	}
+
+	state := StateActive
+	if s.verifyEmail && email != "" {
+		state = StatePending
+	}
+	now := time.Now()
+	user := &User{
+		ID:                newUserID(),
+		Username:          canonical,
+		DisplayName:       username,
+		PasswordHash:      hash,
+		PasswordChangedAt: now,
+		Email:             email,
+		Roles:             []string{RoleUser},
+		State:             state,
+		StateChangedAt:    now,
+	}
+	if setup != nil {
+		setup(user)
+	}
+	return s.repo.Create(user)
+}
+
+// ハッシュ済みのユーザーを取り込む（旧システムからの移行用）
+func (s *UserStore) Import(username string, hash []byte) error {
+	canonical, err := CanonicalUsername(username)
+	if err != nil {
+		return err
+	}
+	err = s.repo.Create(&User{
+		ID:             newUserID(),
+		Username:       canonical,
+		DisplayName:    username,
+		PasswordHash:   hash,
+		Roles:          []string{RoleUser},
+		State:          StateActive,
+		StateChangedAt: time.Now(),
+	})
+	if errors.Is(err, ErrUserExists) {
+		return fmt.Errorf("ユーザー '%s' は既に存在します", username)
+	}
+	return err
+}
+
+// ユーザーを取得する（どの表記で渡しても、正規化して探す）
+func (s *UserStore) Get(username string) (*User, error) {
+	canonical, err := CanonicalUsername(username)
+	if err != nil {
+		// 登録できない名前のユーザーはいない
+		return nil, ErrUserNotFound
+	}
+	return s.repo.Get(canonical)
+}
+
+// ユーザーIDから取得する（セッション・トークンから本人を探すとき）
+func (s *UserStore) GetByID(id string) (*User, error) {
+	return s.repo.GetByID(id)
+}
+
+// メールアドレスからユーザーを取得する
+func (s *UserStore) GetByEmail(email string) (*User, error) {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	return s.repo.GetByEmail(email)
+}
+
+// メールアドレスを確認済みにする（確認待ちなら使える状態になる）
+func (s *UserStore) MarkEmailVerified(id string) (*User, error) {
+	var event *AuditEvent
+	user, err := s.modify(id, func(user *User) {
+		if user.EmailVerifiedAt.IsZero() {
+			user.EmailVerifiedAt = time.Now()
+		}
+		event = activatePending(user)
+	})
+	if err == nil && event != nil {
+		s.recordAudit(*event)
+	}
+	return user, err
+}
+
+// ユーザーのロールを置き換える
+func (s *UserStore) SetRoles(id string, roles []string) error {
+	user, err := s.modify(id, func(user *User) {
+		user.Roles = roles
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("ロールを変更: %s → %v", user.Username, roles)
+	return nil
+}
+
+// ユーザー名を変える。ID は変わらないので、セッション・トークン・メールのリンクはそのまま使える。
+// 前の名前はすぐに空き、別の人が登録できる（その人は別の ID になるので、前の持ち主のセッションは使えない）
+func (s *UserStore) Rename(id, username string) (*User, error) {
+	canonical, err := CanonicalUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	user, unlock, err := s.lockUser(id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err := s.repo.Rename(id, canonical, username); err != nil {
+		return nil, err
+	}
+	log.Printf("ユーザー名を変更: %s → %s", user.Username, canonical)
+	user.Username, user.DisplayName = canonical, username
+	return user, nil
+}
+
+// ユーザーのロックを取って読み、書き換えて保存する
+func (s *UserStore) modify(id string, change func(user *User)) (*User, error) {
+	return s.modifyIf(id, func(user *User) (bool, error) {
+		change(user)
+		return true, nil
+	})
+}
+
+// change が false を返したら保存しない（変えることがなかった）。エラーなら保存せずにそのまま返す
+func (s *UserStore) modifyIf(id string, change func(user *User) (bool, error)) (*User, error) {
+	user, unlock, err := s.lockUser(id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	changed, err := change(user)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		if err := s.repo.Update(user); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// ユーザーのロックを取ってから読む（読んでから取ると、その間の変更を上書きしてしまう）
+func (s *UserStore) lockUser(id string) (*User, func(), error) {
+	unlock := s.locks.Lock(id)
+	user, err := s.repo.GetByID(id)
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	return user, unlock, nil
+}
+
+// ログイン（パスワード検証）。登録時と同じ正規化をしてから探す。
+// ユーザーがいないときもパスワードが違うときも ErrAuthFailed を返し、かかる時間もそろえる
+func (s *UserStore) Authenticate(username, password string) (*User, error) {
+	user, err := s.Get(username)
+	if errors.Is(err, ErrUserNotFound) {
+		// いないユーザーでも、本物と同じだけ時間をかけて検証する（結果は捨てる）
+		if err := s.hasher.Verify(s.dummyHash, password); IsHashPoolBusy(err) {
+			return nil, err
+		}
+		return nil, ErrAuthFailed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 保存されたハッシュの形式に合わせて検証する
+	if err := s.hasher.Verify(user.PasswordHash, password); err != nil {
+		if IsHashPoolBusy(err) {
+			return nil, err
+		}
+		return nil, ErrAuthFailed
+	}
+	// 停止中・退会済みなど。ログインは断るが、理由は AccountStateError の中にだけ持つ
+	if err := s.checkState(user); err != nil {
+		return nil, err
+	}
+
+	// 古い形式・弱いパラメータのハッシュなら、検証できた平文で作り直す
+	s.rehashIfNeeded(user, password)
+	return user, nil
+}
+
+// ハッシュの再計算（ログイン成功時にしか平文は手に入らないので、このタイミングで行う）
+func (s *UserStore) rehashIfNeeded(user *User, password string) {
+	if !s.hasher.NeedsRehash(user.PasswordHash) {
+		return
+	}
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		// 再ハッシュに失敗してもログイン自体は成功させる
+		log.Printf("再ハッシュに失敗: %s: %v", user.Username, err)
+		return
+	}
+
+	// 検証してからここまでの間に、パスワードが変更されているかもしれない。
+	// 読み直して、検証したハッシュのままのときだけ書き戻す（新しいパスワードを古いもので上書きしない）
+	current, unlock, err := s.lockUser(user.ID)
+	if err != nil {
+		return
+	}
+	defer unlock()
+	if !bytes.Equal(current.PasswordHash, user.PasswordHash) {
+		return
+	}
+	current.PasswordHash = hash
+	user.PasswordHash = hash
+	if err := s.repo.Update(current); err != nil {
+		log.Printf("再ハッシュの保存に失敗: %s: %v", user.Username, err)
+		return
+	}
+	log.Printf("パスワードハッシュを更新: %s", user.Username)
+}
+
+// --- パスワード変更と履歴 ---
+
+// 変更のたびに古いハッシュを User.PasswordHistory に残し、
+// 直近 N 個（現在のものを含む）と同じパスワードには戻せないようにする。
+// すぐに N 回変更して元に戻す抜け道を防ぐため、最短使用期間（min_age）も設ける。
+
+// パスワードを変更する。現在のパスワードの確認 → 最短使用期間 → ポリシー → 履歴 の順にチェックする。
+// 検証とハッシュ化（どちらも数百ミリ秒）はロックの外で行い、保存するときだけロックを取る（commitPassword）
+func (s *UserStore) ChangePassword(username, currentPassword, newPassword string) error {
+	// ユーザー名をボディで送るサーバーでは、ここもユーザーの存在を調べる口になる。
+	// いないユーザーでもダミーのハッシュで検証し、パスワード違いと同じエラーを返す
+	user, err := s.Get(username)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return err
+	}
+	hash := s.dummyHash
+	if err == nil {
+		hash = user.PasswordHash
+	}
+
+	// 本人確認（セッションやトークンが盗まれていても、パスワードを知らなければ変更できない）
+	verifyErr := s.hasher.Verify(hash, currentPassword)
+	if IsHashPoolBusy(verifyErr) {
+		return verifyErr
+	}
+	if err != nil || verifyErr != nil {
+		return ErrWrongCurrentPassword
+	}
+
+	var violations []PolicyViolation
+	if s.policy != nil && s.policy.MinAge > 0 && time.Since(user.PasswordChangedAt) < s.policy.MinAge {
+		violations = append(violations, PolicyViolation{
+			Rule:    "min_age",
+			Message: fmt.Sprintf("前回の変更から %v 経過するまで変更できません", s.policy.MinAge),
+		})
+	}
+	if err := s.checkNewPassword(user, newPassword, violations); err != nil {
+		return err
+	}
+
+	newHash, err := s.hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	if err := s.commitPassword(user, newHash, nil); err != nil {
+		return err
+	}
+	log.Printf("パスワード変更: %s", user.Username)
+	return nil
+}
+
+// パスワードをリセットする（現在のパスワードを忘れたとき）。
+// 本人確認はリセットリンクで済んでいるので、現在のパスワードは聞かない。
+// 忘れて困っている人を待たせないよう、最短使用期間（min_age）もチェックしない
+func (s *UserStore) ResetPassword(id, newPassword string) error {
+	user, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if err := s.checkNewPassword(user, newPassword, nil); err != nil {
+		return err
+	}
+	hash, err := s.hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	var event *AuditEvent
+	err = s.commitPassword(user, hash, func(current *User) {
+		// リンクが届いたので、メールアドレスは本人のものだとわかる
+		if current.EmailVerifiedAt.IsZero() {
+			current.EmailVerifiedAt = time.Now()
+		}
+		event = activatePending(current)
+	})
+	if err != nil {
+		return err
+	}
+	if event != nil {
+		s.recordAudit(*event)
+	}
+	log.Printf("パスワードリセット: %s", user.Username)
+	return nil
+}
+
+// 新しいパスワードをポリシーと履歴でチェックする。
+// 呼び出し側で見つけた違反（violations）があれば、まとめて1つの PasswordPolicyError で返す
+func (s *UserStore) checkNewPassword(user *User, newPassword string, violations []PolicyViolation) error {
+	if s.policy != nil {
+		if err := s.policy.Validate(user.Username, newPassword); err != nil {
+			v, ok := PolicyViolations(err)
+			if !ok {
+				return err
+			}
+			violations = append(violations, v...)
+		}
+		reused, err := s.isRecentlyUsed(user, newPassword)
+		if err != nil {
+			return err
+		}
+		if reused {
+			violations = append(violations, PolicyViolation{
+				Rule:    "password_history",
+				Message: fmt.Sprintf("直近 %d 回以内に使ったパスワードは使えません", s.policy.HistorySize+1),
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func (s *UserStore) hashPassword(password string) ([]byte, error) {
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return nil, fmt.Errorf("パスワードのハッシュ化に失敗: %w", err)
+	}
+	return hash, nil
+}
+
+// ロックの外で計算した新しいハッシュを保存する（古いハッシュは履歴へ）。
+// ロックはシャード単位で別のユーザーと共有しているので、持ったままハッシュを計算すると関係のない人まで待たされる。
+// 読んでからロックを取るまでにパスワードが設定し直されていたら（PasswordChangedAt で見る。ログイン時の
+// 再ハッシュはパスワード自体を変えないので対象外）、古いパスワードで済ませたチェックは当てにならないので
+// 保存せずに ErrPasswordChanged を返す。change があれば、同じロックの中でほかの項目も書き換える
+func (s *UserStore) commitPassword(user *User, hash []byte, change func(current *User)) error {
+	current, unlock, err := s.lockUser(user.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if !current.PasswordChangedAt.Equal(user.PasswordChangedAt) {
+		return ErrPasswordChanged
+	}
+	if change != nil {
+		change(current)
+	}
+	s.setPassword(current, hash)
+	if err := s.repo.Update(current); err != nil {
+		return err
+	}
+	*user = *current
+	return nil
+}
+
+// 新しいハッシュを設定し、古いハッシュを履歴に積む（新しい順、最大 HistorySize 個）
+func (s *UserStore) setPassword(user *User, hash []byte) {
+	historySize := 0
+	if s.policy != nil {
+		historySize = s.policy.HistorySize
+	}
+
+	history := append([][]byte{user.PasswordHash}, user.PasswordHistory...)
+	if len(history) > historySize {
+		history = history[:historySize]
+	}
+
+	user.PasswordHistory = history
+	user.PasswordHash = hash
+	user.PasswordChangedAt = time.Now()
+	user.PasswordVersion++
+	user.PasswordResetRequired = false // 管理者に求められたリセットは、新しいパスワードを設定すれば済む
+}
+
+// 現在のパスワードか履歴のどれかと一致するか。
+// ハッシュにはソルトが入っているので、比較は1つずつ検証するしかない（履歴の数だけ時間がかかる）
+func (s *UserStore) isRecentlyUsed(user *User, password string) (bool, error) {
+	for _, hash := range append([][]byte{user.PasswordHash}, user.PasswordHistory...) {
+		err := s.hasher.Verify(hash, password)
+		if err == nil {
+			return true, nil
+		}
+		if IsHashPoolBusy(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
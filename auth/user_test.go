@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestStore(t *testing.T, hasher PasswordHasher) *UserStore {
+	t.Helper()
+	store, err := NewUserStore(NewMemoryUserRepository(), hasher, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// ダミーのハッシュを作れなければ UserStore を作らない（いないユーザーの検証がすぐに終わってしまうため）
+func TestNewUserStoreFailsWithoutDummyHash(t *testing.T) {
+	hasher := &busyHasher{PasswordHasher: NewBcryptHasher(bcrypt.MinCost, false)}
+	hasher.busy.Store(true)
+	if _, err := NewUserStore(NewMemoryUserRepository(), hasher, nil); err == nil {
+		t.Fatal("ダミーハッシュを作れないのに UserStore ができた")
+	}
+}
+
+// 検証に渡されたハッシュを記録する
+type countingHasher struct {
+	PasswordHasher
+	mu       sync.Mutex
+	verified [][]byte
+}
+
+func (h *countingHasher) Verify(hash []byte, password string) error {
+	h.mu.Lock()
+	h.verified = append(h.verified, hash)
+	h.mu.Unlock()
+	return h.PasswordHasher.Verify(hash, password)
+}
+
+func (h *countingHasher) reset() [][]byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	verified := h.verified
+	h.verified = nil
+	return verified
+}
+
+// いないユーザーもパスワード違いも同じ ErrAuthFailed を返し、どちらもハッシュの検証を1回だけ行う
+// （いないユーザーはダミーのハッシュで検証する。とばすと応答時間でユーザーの存在がわかる）
+func TestAuthenticateUnknownUserVerifiesDummyHash(t *testing.T) {
+	hasher := &countingHasher{PasswordHasher: NewBcryptHasher(bcrypt.MinCost, false)}
+	store := newTestStore(t, hasher)
+	if err := store.Register("hanako", "", "correct-password"); err != nil {
+		t.Fatal(err)
+	}
+	user, err := store.Get("hanako")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.dummyHash) == 0 {
+		t.Fatal("ダミーのハッシュがない")
+	}
+
+	for _, tc := range []struct {
+		name, username string
+		want           []byte
+	}{
+		{"いないユーザー", "nobody", store.dummyHash},
+		{"パスワード違い", "hanako", user.PasswordHash},
+	} {
+		hasher.reset()
+		if _, err := store.Authenticate(tc.username, "wrong-password"); !errors.Is(err, ErrAuthFailed) {
+			t.Errorf("%s: err = %v, want ErrAuthFailed", tc.name, err)
+		}
+		verified := hasher.reset()
+		if len(verified) != 1 || !bytes.Equal(verified[0], tc.want) {
+			t.Errorf("%s: 検証したハッシュ = %q, want [%q]", tc.name, verified, tc.want)
+		}
+	}
+}
+
+// 古い形式のハッシュは、ログインに成功したときだけ検証できた平文で作り直す
+func TestAuthenticateRehashesOutdatedHash(t *testing.T) {
+	repo := NewMemoryUserRepository()
+	old, err := NewUserStore(repo, NewBcryptHasher(bcrypt.MinCost, false), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Register("taro", "", "secret-password"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 設定を argon2id に切り替えて起動し直した
+	hasher := NewMultiHasher(NewArgon2idHasher(testArgon2Params), NewBcryptHasher(bcrypt.MinCost, false))
+	store, err := NewUserStore(repo, hasher, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := store.Get("taro")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// パスワードが違えば作り直さない（平文がわからない）
+	if _, err := store.Authenticate("taro", "wrong-password"); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("err = %v, want ErrAuthFailed", err)
+	}
+	if user, _ := store.Get("taro"); !bytes.Equal(user.PasswordHash, before.PasswordHash) {
+		t.Fatal("ログインに失敗したのにハッシュが変わった")
+	}
+
+	if _, err := store.Authenticate("taro", "secret-password"); err != nil {
+		t.Fatal(err)
+	}
+	after, err := store.Get("taro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(after.PasswordHash, []byte("$argon2id$")) {
+		t.Fatalf("argon2id で作り直されていない: %s", after.PasswordHash)
+	}
+	// 再ハッシュはパスワードの変更ではない（JWT やセッションを無効にしない）
+	if after.PasswordVersion != before.PasswordVersion || !after.PasswordChangedAt.Equal(before.PasswordChangedAt) {
+		t.Error("再ハッシュでパスワードの版・変更日時が変わった")
+	}
+	if _, err := store.Authenticate("taro", "secret-password"); err != nil {
+		t.Fatalf("作り直したハッシュでログインできない: %v", err)
+	}
+}
+
+// 違反したルールの名前だけを取り出す
+func violatedRules(err error) []string {
+	violations, _ := PolicyViolations(err)
+	var rules []string
+	for _, v := range violations {
+		rules = append(rules, v.Rule)
+	}
+	return rules
+}
+
+// 現在のパスワードと直近 history_size 個には戻せず、それより古いものには戻せる
+func TestChangePasswordRejectsRecentPasswords(t *testing.T) {
+	policy, err := NewPasswordPolicy(PolicyConfig{MinLength: 8, HistorySize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewUserStore(NewMemoryUserRepository(), NewBcryptHasher(bcrypt.MinCost, false), policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwords := []string{"first-orchard-1", "second-lantern-2", "third-harbor-3", "fourth-meadow-4"}
+	if err := store.Register("taro", "", passwords[0]); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(passwords); i++ {
+		if err := store.ChangePassword("taro", passwords[i-1], passwords[i]); err != nil {
+			t.Fatalf("%d 回目の変更: %v", i, err)
+		}
+	}
+	user, err := store.Get("taro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(user.PasswordHistory) != 2 {
+		t.Fatalf("履歴が %d 個, want 2", len(user.PasswordHistory))
+	}
+
+	current := passwords[3]
+	for _, tc := range []struct {
+		password string
+		reused   bool
+	}{
+		{passwords[3], true}, // 現在のもの
+		{passwords[2], true},
+		{passwords[1], true},
+		{passwords[0], false}, // 履歴から押し出された
+	} {
+		err := store.ChangePassword("taro", current, tc.password)
+		rules := violatedRules(err)
+		if tc.reused && !slices.Equal(rules, []string{"password_history"}) {
+			t.Errorf("%s: err = %v, want password_history", tc.password, err)
+		}
+		if !tc.reused && err != nil {
+			t.Errorf("%s: 履歴にないのに変更できない: %v", tc.password, err)
+		}
+	}
+
+	// リセットでも履歴は見る
+	if err := store.ResetPassword(user.ID, passwords[3]); !slices.Equal(violatedRules(err), []string{"password_history"}) {
+		t.Errorf("リセットで直近のパスワードに戻せた: %v", err)
+	}
+}
+
+// 最短使用期間の間は変更できない（違反はポリシーや履歴の違反とまとめて返す）。リセットは期間を見ない
+func TestChangePasswordMinAge(t *testing.T) {
+	policy, err := NewPasswordPolicy(PolicyConfig{MinLength: 8, HistorySize: 1, MinAgeMinutes: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewUserStore(NewMemoryUserRepository(), NewBcryptHasher(bcrypt.MinCost, false), policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Register("taro", "", "first-orchard-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.ChangePassword("taro", "wrong-password", "second-lantern-2"); !errors.Is(err, ErrWrongCurrentPassword) {
+		t.Fatalf("err = %v, want ErrWrongCurrentPassword", err)
+	}
+	err = store.ChangePassword("taro", "first-orchard-1", "first-orchard-1")
+	if rules := violatedRules(err); !slices.Equal(rules, []string{"min_age", "password_history"}) {
+		t.Fatalf("rules = %v, want [min_age password_history]", rules)
+	}
+
+	user, err := store.Get("taro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ResetPassword(user.ID, "second-lantern-2"); err != nil {
+		t.Fatalf("リセットが最短使用期間で断られた: %v", err)
+	}
+	if _, err := store.Authenticate("taro", "second-lantern-2"); err != nil {
+		t.Fatal(err)
+	}
+}
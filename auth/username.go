@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/secure/precis"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ===================
+// ユーザー名の正規化
+// ===================
+
+// 見た目が同じ・ほぼ同じユーザー名は、同じアカウントとして扱う。
+//
+//	Taro / taro / ｔａｒｏ（全角）  → taro
+//
+// 保存・検索のキーには正規化した名前を使い、入力されたままの名前は表示名として別に持つ。
+// 別の文字体系の似た文字（キリル文字の а とラテン文字の a など）を混ぜた名前は、なりすましに使えるので拒否する。
+
+var ErrInvalidUsername = errors.New("使えないユーザー名です")
+
+// ユーザー名を正規化する（NFKC → PRECIS UsernameCaseMapped）。
+//   - NFKC: 全角英数字・合字（ﬁ）・丸数字（①）などを普通の文字にそろえる
+//   - PRECIS（RFC 8265）: 幅の統一・大文字小文字の統一（case folding）をし、空白や制御文字などを拒否する
+//
+// 何度かけても同じ結果になるので、すでに正規化した名前を渡してもよい
+func CanonicalUsername(username string) (string, error) {
+	if strings.TrimSpace(username) == "" {
+		return "", fmt.Errorf("%w: ユーザー名は必須です", ErrInvalidUsername)
+	}
+
+	canonical, err := precis.UsernameCaseMapped.String(norm.NFKC.String(username))
+	if err != nil {
+		return "", fmt.Errorf("%w: 空白・記号・制御文字などは使えません", ErrInvalidUsername)
+	}
+	if err := checkConfusable(canonical); err != nil {
+		return "", err
+	}
+	return canonical, nil
+}
+
+// --- 紛らわしい文字（confusables）---
+
+// 判定する文字体系。数字や記号（Common）、結合文字（Inherited）はどの体系とも混ぜてよい
+var scripts = []struct {
+	name  string
+	table *unicode.RangeTable
+}{
+	{"ラテン文字", unicode.Latin},
+	{"キリル文字", unicode.Cyrillic},
+	{"ギリシャ文字", unicode.Greek},
+	{"アルメニア文字", unicode.Armenian},
+	{"漢字", unicode.Han},
+	{"ひらがな", unicode.Hiragana},
+	{"カタカナ", unicode.Katakana},
+	{"ハングル", unicode.Hangul},
+	{"アラビア文字", unicode.Arabic},
+	{"ヘブライ文字", unicode.Hebrew},
+}
+
+// 混ぜてもよい組み合わせ（Unicode TR39 の Highly Restrictive）。
+// 日本語・中国語・韓国語の名前は、もともと複数の文字体系を混ぜて書く
+var allowedScriptSets = [][]string{
+	{"ラテン文字", "漢字", "ひらがな", "カタカナ"},
+	{"ラテン文字", "漢字", "ハングル"},
+}
+
+// ラテン文字とほぼ同じ形のキリル文字・ギリシャ文字（小文字化した後のもの）
+var latinLookalikes = map[rune]bool{
+	// キリル文字
+	'а': true, 'в': true, 'е': true, 'к': true, 'м': true, 'н': true, 'о': true,
+	'р': true, 'с': true, 'т': true, 'у': true, 'х': true, 'і': true, 'ј': true,
+	'ѕ': true, 'ԁ': true, 'ԛ': true, 'ԝ': true, 'ӏ': true, 'һ': true,
+	// ギリシャ文字
+	'α': true, 'β': true, 'γ': true, 'ι': true, 'κ': true, 'ν': true, 'ο': true,
+	'ρ': true, 'τ': true, 'υ': true, 'χ': true,
+}
+
+// 正規化済みの名前を調べ、なりすましに使えそうなら ErrInvalidUsername を返す
+func checkConfusable(username string) error {
+	used := make(map[string]bool)
+	var order []string
+	for _, r := range username {
+		for _, s := range scripts {
+			if unicode.Is(s.table, r) {
+				if !used[s.name] {
+					used[s.name] = true
+					order = append(order, s.name)
+				}
+				break
+			}
+		}
+	}
+
+	// 1つの文字体系だけでも、全部がラテン文字にそっくりな文字なら拒否する（例: キリル文字だけの "аре"）
+	if len(order) == 1 && order[0] != "ラテン文字" && allLatinLookalikes(username) {
+		return fmt.Errorf("%w: ラテン文字と見分けがつかない%sだけでできています", ErrInvalidUsername, order[0])
+	}
+	if len(order) <= 1 {
+		return nil
+	}
+
+	for _, allowed := range allowedScriptSets {
+		if containsAll(allowed, order) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: 異なる文字体系（%s）が混ざっています", ErrInvalidUsername, strings.Join(order, "と"))
+}
+
+// 文字（数字・記号を除く）が全てラテン文字にそっくりか
+func allLatinLookalikes(username string) bool {
+	letters := 0
+	for _, r := range username {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if !latinLookalikes[r] {
+			return false
+		}
+	}
+	return letters > 0
+}
+
+func containsAll(set, items []string) bool {
+	for _, item := range items {
+		found := false
+		for _, s := range set {
+			if s == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 見た目が同じ名前は同じキーになり、なりすましに使える名前・空の名前は ErrInvalidUsername になる
+func TestCanonicalUsername(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		want  string // 空なら ErrInvalidUsername を期待する
+	}{
+		{"taro", "taro"},
+		{"Taro", "taro"},
+		{"TARO", "taro"},
+		{"ｔａｒｏ", "taro"},   // 全角
+		{"Ｔａｒｏ", "taro"},   // 全角の大文字
+		{"ﬁle", "file"},    // 合字
+		{"user①", "user1"}, // 丸数字
+		{"taro_01", "taro_01"},
+		{"たろう", "たろう"},
+		{"山田taro", "山田taro"},
+		{"иван", "иван"}, // キリル文字だけでも、ラテン文字と見分けがつくならよい
+
+		{"", ""},
+		{"   ", ""},
+		{"\t", ""},
+		{"taro yamada", ""}, // 空白
+		{"taro\x00", ""},    // 制御文字
+		{"tаro", ""},        // キリル文字の а が混ざっている
+		{"tαro", ""},        // ギリシャ文字の α が混ざっている
+		{"аре", ""},         // キリル文字だけだが、ラテン文字の "ape" と見分けがつかない
+		{"taroиван", ""},    // ラテン文字とキリル文字
+	} {
+		got, err := CanonicalUsername(tc.input)
+		if tc.want == "" {
+			if !errors.Is(err, ErrInvalidUsername) {
+				t.Errorf("%q: (%q, %v), want ErrInvalidUsername", tc.input, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%q: (%q, %v), want %q", tc.input, got, err, tc.want)
+			continue
+		}
+		// 正規化した名前をもう一度かけても変わらない
+		if again, err := CanonicalUsername(got); err != nil || again != got {
+			t.Errorf("%q: 2回目の正規化で (%q, %v)", got, again, err)
+		}
+	}
+}
+
+// 空白だけのユーザー名は、登録でも名前の変更でも 400（500 ではない）
+func TestBlankUsernameIsBadRequest(t *testing.T) {
+	users := newTestStore(t, NewBcryptHasher(bcrypt.MinCost, false))
+	api := &API{Users: users}
+	rec := postJSON(api.HandleRegister, "/register", AuthRequest{Username: "   ", Password: "correct-password"})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("登録: status = %d, want 400", rec.Code)
+	}
+
+	if err := users.Register("taro", "", "correct-password"); err != nil {
+		t.Fatal(err)
+	}
+	user, err := users.Get("taro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Rename(user.ID, " "); !errors.Is(err, ErrInvalidUsername) {
+		t.Fatalf("名前の変更: err = %v, want ErrInvalidUsername", err)
+	}
+}
+
+// 0003 の lower() で正規化しきれなかった名前は、DB を開いたときに正規化し直す。
+// ぶつかる名前・使えない名前は書き換えずに返す
+func TestOpenSQLiteCanonicalizesUsernames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.db")
+	db, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewSQLiteUserRepository(db)
+	ids := map[string]string{} // 作ったときの名前 → ID
+	for _, name := range []string{"taro", "hanako", "jiro", "saburo", "shiro"} {
+		u := newRepoUser(name, "")
+		if err := repo.Create(u); err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = u.ID
+	}
+	for name, raw := range map[string]string{
+		"taro":   "ｔａｒｏ",         // 全角 → taro
+		"jiro":   "ﬁle",          // 合字 → file
+		"saburo": "ｈａｎａｋｏ",       // 正規化すると hanako とぶつかる
+		"shiro":  "shiro yamada", // 空白は今のルールでは使えない
+	} {
+		if _, err := db.Exec(`UPDATE users SET username = ? WHERE username = ?`, raw, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	db, err = OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo = NewSQLiteUserRepository(db)
+	for canonical, id := range map[string]string{"taro": ids["taro"], "file": ids["jiro"], "hanako": ids["hanako"]} {
+		if u, err := repo.Get(canonical); err != nil || u.ID != id {
+			t.Errorf("%s: (%v, %v), want ID %s", canonical, u, err, id)
+		}
+	}
+	// 書き換えられなかったものは、もう一度かけても同じように返る
+	conflicts, err := canonicalizeUsernames(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, c := range conflicts {
+		got[c.UserID] = true
+	}
+	if len(conflicts) != 2 || !got[ids["saburo"]] || !got[ids["shiro"]] {
+		t.Fatalf("conflicts = %v, want saburo と shiro", conflicts)
+	}
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ===================
+// メールアドレスの確認
+// ===================
+
+// 登録時にメールアドレスを受け取り、確認リンクを送る。リンクを開くまでログインできない。
+//
+//	登録 → 確認メール（/email/verify?token=...）→ 確認 → ログインできる
+//
+// 他人のメールアドレスで登録されても、その人がリンクを開かない限りアカウントは使えない。
+
+type EmailVerifier struct {
+	users   *UserStore
+	tokens  OneTimeTokenRepository
+	mailer  Mailer
+	baseURL string        // リンクの先頭（例: http://localhost:3000）
+	ttl     time.Duration // リンクの有効期限
+}
+
+func NewEmailVerifier(users *UserStore, tokens OneTimeTokenRepository, mailer Mailer, baseURL string, ttl time.Duration) *EmailVerifier {
+	return &EmailVerifier{
+		users:   users,
+		tokens:  tokens,
+		mailer:  mailer,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		ttl:     ttl,
+	}
+}
+
+// 確認メールを送る。前に送ったリンクは使えなくなる（最後に届いたメールだけが有効）
+func (v *EmailVerifier) SendVerification(user *User) error {
+	if !user.NeedsEmailVerification() {
+		return nil
+	}
+	if err := v.tokens.DeleteUser(user.ID, TokenPurposeVerifyEmail); err != nil {
+		return err
+	}
+	raw, err := issueOneTimeToken(v.tokens, user.ID, TokenPurposeVerifyEmail, v.ttl)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(`%s さん
+
+登録ありがとうございます。
+次のリンクを開いて、メールアドレスを確認してください（有効期限: %v）。
+
+%s/email/verify?token=%s
+
+心当たりがない場合は、このメールを無視してください。
+`, user.DisplayName, v.ttl, v.baseURL, raw)
+
+	if err := v.mailer.Send(Message{To: user.Email, Subject: "メールアドレスの確認", Body: body}); err != nil {
+		return fmt.Errorf("確認メールの送信に失敗: %w", err)
+	}
+	log.Printf("確認メールを送信: %s", user.Username)
+	return nil
+}
+
+// リンクのトークンを使ってメールアドレスを確認済みにする
+func (v *EmailVerifier) Verify(raw string) (*User, error) {
+	token, err := consumeOneTimeToken(v.tokens, raw, TokenPurposeVerifyEmail)
+	if err != nil {
+		return nil, err
+	}
+	return v.users.MarkEmailVerified(token.UserID)
+}
+
+// 確認メールを送り直す。登録されていない・確認済みのアドレスでも何も言わない
+// （返事の違いから、そのアドレスが登録されているかを調べられないように）
+func (v *EmailVerifier) Resend(email string) error {
+	user, err := v.users.GetByEmail(email)
+	if err != nil {
+		return nil
+	}
+	return v.SendVerification(user)
+}
+
+// 登録済みのメールアドレスで登録しようとしたことを、そのアドレスに知らせる。
+// 画面には新規登録と同じ返事をするので、登録できなかったことはメールを受け取れる本人にしかわからない。
+// ユーザー名が使われていたことは知らせない（入力したアドレスに届くので、他人の名前を自分の受信箱で調べられてしまう）
+func (v *EmailVerifier) SendDuplicateNotice(email string) error {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(`このメールアドレスで新規登録がありましたが、このアドレスは既に登録されています。
+
+パスワードを忘れた場合は、次からリセットできます。
+%s/password/forgot
+
+心当たりがない場合は、このメールを無視してください。
+`, v.baseURL)
+
+	if err := v.mailer.Send(Message{To: email, Subject: "新規登録について", Body: body}); err != nil {
+		return fmt.Errorf("お知らせメールの送信に失敗: %w", err)
+	}
+	log.Printf("登録済みのアドレスのため登録できなかったことを通知")
+	return nil
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 送ったメールを覚えておく Mailer
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (m *recordingMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var verifyLink = regexp.MustCompile(`/email/verify\?token=(\S+)`)
+
+// 最後に送ったメールのリンクからトークンを取り出す
+func (m *recordingMailer) lastToken(t *testing.T) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.sent) == 0 {
+		t.Fatal("メールが送られていない")
+	}
+	match := verifyLink.FindStringSubmatch(m.sent[len(m.sent)-1].Body)
+	if match == nil {
+		t.Fatalf("メールに確認リンクがない:\n%s", m.sent[len(m.sent)-1].Body)
+	}
+	return match[1]
+}
+
+// 確認するまではログインできず、リンクを開けばログインできる。リンクは1回しか使えない
+func TestEmailVerificationFlow(t *testing.T) {
+	users := newTestStore(t, NewBcryptHasher(bcrypt.MinCost, false))
+	users.verifyEmail = true
+	tokens := NewMemoryOneTimeTokenRepository()
+	mailer := &recordingMailer{}
+	verifier := NewEmailVerifier(users, tokens, mailer, "http://localhost:3000/", time.Hour)
+
+	if err := users.Register("taro", "Taro@Example.com", "correct-password"); err != nil {
+		t.Fatal(err)
+	}
+	user, err := users.Get("taro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Authenticate("taro", "correct-password"); !errors.Is(err, ErrAccountUnavailable) {
+		t.Fatalf("確認前のログイン: err = %v, want ErrAccountUnavailable", err)
+	}
+
+	if err := verifier.SendVerification(user); err != nil {
+		t.Fatal(err)
+	}
+	if to := mailer.sent[0].To; to != "taro@example.com" {
+		t.Errorf("宛先 = %q, want 正規化したアドレス", to)
+	}
+	first := mailer.lastToken(t)
+	// 送り直すと、前のリンクは使えなくなる
+	if err := verifier.Resend("taro@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	second := mailer.lastToken(t)
+	if _, err := verifier.Verify(first); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("前のリンク: err = %v, want ErrTokenInvalid", err)
+	}
+
+	if _, err := verifier.Verify(second); err != nil {
+		t.Fatalf("確認できない: %v", err)
+	}
+	if _, err := users.Authenticate("taro", "correct-password"); err != nil {
+		t.Fatalf("確認した後にログインできない: %v", err)
+	}
+	if _, err := verifier.Verify(second); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("使ったリンク: err = %v, want ErrTokenInvalid", err)
+	}
+
+	// 確認済みのアドレス・登録されていないアドレスには送らないが、エラーにもしない
+	sent := len(mailer.sent)
+	for _, email := range []string{"taro@example.com", "nobody@example.com"} {
+		if err := verifier.Resend(email); err != nil {
+			t.Errorf("%s: %v", email, err)
+		}
+	}
+	if len(mailer.sent) != sent {
+		t.Errorf("送らなくてよいメールを %d 通送った", len(mailer.sent)-sent)
+	}
+}
+
+// 保存するのはハッシュだけ。期限切れ・用途違い・空のトークンは使えない
+func TestOneTimeTokenRules(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		ttl     time.Duration
+		purpose string // 使うときの用途
+		raw     func(raw string) string
+		ok      bool
+	}{
+		{"有効", time.Hour, TokenPurposeVerifyEmail, func(raw string) string { return raw }, true},
+		{"期限切れ", -time.Second, TokenPurposeVerifyEmail, func(raw string) string { return raw }, false},
+		{"用途違い", time.Hour, TokenPurposeResetPassword, func(raw string) string { return raw }, false},
+		{"空", time.Hour, TokenPurposeVerifyEmail, func(string) string { return "" }, false},
+		{"ハッシュをそのまま", time.Hour, TokenPurposeVerifyEmail, hashToken, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens := NewMemoryOneTimeTokenRepository()
+			raw, err := issueOneTimeToken(tokens, "user-1", TokenPurposeVerifyEmail, tc.ttl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, stored := tokens.tokens[raw]; stored {
+				t.Fatal("生のトークンが保存されている")
+			}
+			token, err := consumeOneTimeToken(tokens, tc.raw(raw), tc.purpose)
+			if tc.ok != (err == nil) {
+				t.Fatalf("err = %v, want ok=%v", err, tc.ok)
+			}
+			if tc.ok && token.UserID != "user-1" {
+				t.Errorf("UserID = %q", token.UserID)
+			}
+			if !tc.ok && !errors.Is(err, ErrTokenInvalid) {
+				t.Errorf("err = %v, want ErrTokenInvalid", err)
+			}
+		})
+	}
+}
+
+// FileOutbox は送るはずのメールを .eml として書き出す（件名は MIME エンコードする）
+func TestFileOutboxWritesMessage(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	outbox := NewFileOutbox(dir, "no-reply@example.com")
+	for range 2 {
+		if err := outbox.Send(Message{To: "taro@example.com", Subject: "メールアドレスの確認", Body: "1行目\n2行目\n"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*taro@example.com.eml"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("書き出したファイル = %v, %v, want 2つ", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	eml := string(data)
+	for _, want := range []string{"From: no-reply@example.com\r\n", "To: taro@example.com\r\n", "Subject: =?utf-8?q?", "\r\n\r\n1行目\r\n2行目\r\n"} {
+		if !strings.Contains(eml, want) {
+			t.Errorf("%q がない:\n%s", want, eml)
+		}
+	}
+}